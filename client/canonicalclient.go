@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"golang.org/x/net/http2"
 	"io"
+	"log"
 	"net/http"
+	"net/http/httptrace"
 	"rawh/common"
+	"sort"
 	"strings"
 )
 
@@ -15,9 +18,13 @@ type CanonicalClient struct {
 	httpVersion common.HttpVersion
 	httpClient  *http.Client
 	tlsConfig   *tls.Config
+	options     Options
 }
 
-func NewCanonicalClient(tlsVersionName string, insecure bool, httpVersionName string, verbose bool) (Client, error) {
+func NewCanonicalClient(tlsVersionName string, insecure bool, httpVersionName string, verbose bool, options Options) (Client, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 	tlsVer, err := common.ParseTlsVersionName(tlsVersionName)
 	if err != nil {
 		return nil, err
@@ -28,6 +35,19 @@ func NewCanonicalClient(tlsVersionName string, insecure bool, httpVersionName st
 	}
 	transport := &http.Transport{
 		TLSClientConfig: tlsConfig,
+		// the body is decoded on demand, so the raw size and hash can be reported
+		DisableCompression: true,
+	}
+	if proxyURL := options.Proxy.proxyURL(); proxyURL != nil {
+		if options.Proxy.Mode != ProxyModeAuto {
+			return nil, fmt.Errorf("the canonical client sends the absolute-form for http and CONNECT for https targets, the proxy mode '%s' requires the raw client", options.Proxy.Mode)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		transport.ProxyConnectHeader = http.Header{}
+		for _, line := range options.Proxy.Headers {
+			name, value, _ := common.SplitHeaderLine(line) // validated by the options
+			transport.ProxyConnectHeader.Add(name, strings.TrimSpace(value))
+		}
 	}
 	httpVersion, err := common.ParseHttpVersionName(httpVersionName)
 	if err != nil {
@@ -43,15 +63,39 @@ func NewCanonicalClient(tlsVersionName string, insecure bool, httpVersionName st
 		verbose:     verbose,
 		tlsConfig:   tlsConfig,
 		httpVersion: httpVersion,
-		httpClient:  &http.Client{Transport: transport},
+		httpClient:  &http.Client{Transport: transport, CheckRedirect: options.Redirect.checkCanonicalRedirect},
+		options:     options,
 	}, nil
 }
 
-func (c *CanonicalClient) DoRequest(method string, url string, customHeaders common.MultiString, data string) error {
-	req, err := http.NewRequest(method, url, strings.NewReader(data))
+func (c *CanonicalClient) DoRequest(method string, url string, customHeaders common.MultiString, body *common.Body) error {
+	// every (re)sending of the body, e.g. after a 307 redirect, is hashed from the beginning
+	var bodyDigest *common.BodyDigest
+	openBody := func() (io.ReadCloser, error) {
+		if body.IsEmpty() {
+			bodyDigest = common.NewBodyDigest()
+			return http.NoBody, nil
+		}
+		bodyReader, err := body.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error opening request body: %v", err)
+		}
+		bodyDigest = common.NewBodyDigest()
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(bodyReader, bodyDigest), bodyReader}, nil
+	}
+	bodyReader, err := openBody()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
 		return fmt.Errorf("error creating request: %v", err)
 	}
+	req.ContentLength = body.Size // -1 is unknown
+	req.GetBody = openBody
 	req.Proto, req.ProtoMajor, req.ProtoMinor = c.httpVersion.Proto, c.httpVersion.Major, c.httpVersion.Minor
 	var reqHeaders = common.NewHttpHeaders(true)
 	for _, headerLine := range customHeaders {
@@ -60,48 +104,133 @@ func (c *CanonicalClient) DoRequest(method string, url string, customHeaders com
 			req.Header.Add(key, val)
 		}
 	}
-	req.Header.Add(common.ContentLengthHeaderName, fmt.Sprint(len(data)))
+	// the body of unknown size is sent chunked by the transport
+	if body.SizeKnown() {
+		req.Header.Add(common.ContentLengthHeaderName, fmt.Sprint(body.Size))
+	}
+	if req.URL.Scheme == "http" && c.options.Proxy.URL != "" && !c.options.Proxy.socks() {
+		// the absolute-form request carries the proxy headers, the CONNECT request gets them from the transport
+		for name, values := range c.httpClient.Transport.(*http.Transport).ProxyConnectHeader {
+			req.Header[name] = append(req.Header[name], values...)
+		}
+	}
+	if c.options.Sse.Enabled && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", sseContentType)
+	}
 	req.Host = reqHeaders.Host
+	exchange := newExchange(method, url)
+	exchange.requestBody = body
+	requestTarget := req.URL.RequestURI()
+	if req.URL.Scheme == "http" && c.options.Proxy.URL != "" && !c.options.Proxy.socks() {
+		requestTarget = req.URL.Scheme + "://" + req.URL.Host + requestTarget // the transport sends the absolute-form to the proxy
+	}
+	exchange.Request.StartLine = fmt.Sprintf("%s %s %s", req.Method, requestTarget, req.Proto)
+	exchange.Request.HeaderLines = append([]string{"Host: " + req.URL.Host}, canonicalHeaderLines(req.Header)...)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), exchangeTrace(exchange)))
 	c.verboseRequest(req)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("error sending request: %v", err)
+		err = fmt.Errorf("error sending request: %v", err)
+		exchange.Error = err.Error()
+		c.options.emitExchange(exchange)
+		return err
 	}
 	defer common.SafeClose(resp.Body)
+	exchange.Request.Body = newBodyInfo(bodyDigest)
+	if c.options.OutputFormat != OutputFormatJson {
+		exchange.Request.Body.log("request")
+	}
 	c.verboseResponse(resp)
-	body, err := io.ReadAll(resp.Body)
+	exchange.Response = &ExchangeResponse{
+		StatusLine:  fmt.Sprintf("%s %s", resp.Proto, resp.Status),
+		StatusCode:  resp.StatusCode,
+		HeaderLines: canonicalHeaderLines(resp.Header),
+	}
+	if resp.TLS != nil {
+		exchange.Connection.Tls = newTlsInfo(*resp.TLS)
+	}
+	monitor := newSseMonitor(exchange, c.options)
+	exchange.Response.Body, err = processResponseBody(resp.Body, resp.Header.Get(common.ContentEncodingHeaderName), c.options, monitor)
+	monitor.finish()
+	exchange.Response.TrailerLines = canonicalHeaderLines(resp.Trailer)
+	if c.options.OutputFormat != OutputFormatJson {
+		exchange.Response.Body.log("response")
+	}
 	if err != nil {
-		return fmt.Errorf("error reading response: %v", err)
+		exchange.Error = err.Error()
+	}
+	c.options.emitExchange(exchange)
+	return err
+}
+
+// exchangeTrace records the timings and connection details of the canonical client, the last hop wins when redirects are followed.
+func exchangeTrace(exchange *Exchange) *httptrace.ClientTrace {
+	var tlsStart float64
+	return &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			exchange.Timings.ConnectMs = exchange.since()
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = exchange.since()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			exchange.Timings.TlsMs = exchange.since() - tlsStart
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			exchange.Connection.LocalAddress = info.Conn.LocalAddr().String()
+			exchange.Connection.RemoteAddress = info.Conn.RemoteAddr().String()
+			exchange.Connection.Reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			exchange.Timings.RequestMs = exchange.since()
+		},
+		GotFirstResponseByte: func() {
+			exchange.Timings.FirstByteMs = exchange.since()
+		},
+	}
+}
+
+// canonicalHeaderLines returns the header lines sorted by name, the canonical client does not keep their order.
+func canonicalHeaderLines(header http.Header) []string {
+	lines := []string{}
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			lines = append(lines, key+": "+value)
+		}
 	}
-	fmt.Println(string(body))
-	return nil
+	return lines
 }
 
 func (c *CanonicalClient) verboseResponse(resp *http.Response) {
 	if c.verbose {
-		fmt.Printf("< HTTP/%d.%d %s\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
+		log.Printf("< HTTP/%d.%d %s\n", resp.ProtoMajor, resp.ProtoMinor, resp.Status)
 		if len(resp.Header) > 0 {
 			for k, va := range resp.Header {
 				for _, v := range va {
-					fmt.Printf("< %s: %s\n", k, v)
+					log.Printf("< %s: %s\n", k, v)
 				}
 			}
 		}
-		fmt.Printf("<\n")
+		log.Printf("<\n")
 	}
 }
 
 func (c *CanonicalClient) verboseRequest(req *http.Request) {
 	if c.verbose {
-		fmt.Printf("> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
-		fmt.Printf("> req.Host: %s\n", req.Host)
+		log.Printf("> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+		log.Printf("> req.Host: %s\n", req.Host)
 		if len(req.Header) > 0 {
 			for k, va := range req.Header {
 				for _, v := range va {
-					fmt.Printf("> %s: %s\n", k, v)
+					log.Printf("> %s: %s\n", k, v)
 				}
 			}
 		}
-		fmt.Printf(">\n")
+		log.Printf(">\n")
 	}
 }
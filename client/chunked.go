@@ -0,0 +1,201 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deliberate violations of the chunked transfer coding, useful to test how intermediaries react to them.
+const (
+	ChunkedMalformInvalidHex   = "invalid-hex"
+	ChunkedMalformWrongSize    = "wrong-size"
+	ChunkedMalformNoFinalChunk = "no-final-chunk"
+	ChunkedMalformClAndTe      = "cl-and-te"
+)
+
+var chunkedMalforms = []string{ChunkedMalformInvalidHex, ChunkedMalformWrongSize, ChunkedMalformNoFinalChunk, ChunkedMalformClAndTe}
+
+type ChunkedOptions struct {
+	Enabled bool
+	// Sizes is the chunk sizes specification: a fixed size '1KB', a random range '10-100' or a list '1,5,10' (the last size repeats).
+	Sizes     string
+	Delay     time.Duration
+	Extension string
+	Trailers  []string
+	Malforms  []string
+}
+
+func (o *ChunkedOptions) Validate() error {
+	for _, malform := range o.Malforms {
+		if !containsString(chunkedMalforms, malform) {
+			return fmt.Errorf("unsupported chunked malformation: %s (options: %s)", malform, strings.Join(chunkedMalforms, ", "))
+		}
+	}
+	for _, trailer := range o.Trailers {
+		if _, _, err := common.SplitHeaderLine(trailer); err != nil {
+			return fmt.Errorf("invalid trailer: %v", err)
+		}
+	}
+	_, err := newChunkSizer(o.Sizes)
+	return err
+}
+
+func (o *ChunkedOptions) malformed(malform string) bool {
+	return containsString(o.Malforms, malform)
+}
+
+// trailerNames returns the exact-case trailer names to be announced in the 'Trailer' header.
+func (o *ChunkedOptions) trailerNames() string {
+	var names []string
+	for _, trailer := range o.Trailers {
+		name, _, _ := common.SplitHeaderLine(trailer)
+		names = append(names, strings.TrimSpace(name))
+	}
+	return strings.Join(names, ", ")
+}
+
+type chunkSizer struct {
+	sizes    []int
+	min, max int
+	next     int
+}
+
+func newChunkSizer(spec string) (*chunkSizer, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		spec = "1KB"
+	}
+	if lo, hi, found := strings.Cut(spec, "-"); found {
+		minSize, err := common.ParsePrittyByteSize(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size range '%s': %v", spec, err)
+		}
+		maxSize, err := common.ParsePrittyByteSize(hi)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size range '%s': %v", spec, err)
+		}
+		if minSize <= 0 || maxSize < minSize {
+			return nil, fmt.Errorf("invalid chunk size range '%s'", spec)
+		}
+		return &chunkSizer{min: minSize, max: maxSize}, nil
+	}
+	var sizes []int
+	for _, part := range strings.Split(spec, ",") {
+		size, err := common.ParsePrittyByteSize(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size '%s': %v", part, err)
+		}
+		if size <= 0 {
+			return nil, fmt.Errorf("invalid chunk size '%s': must be positive", part)
+		}
+		sizes = append(sizes, size)
+	}
+	return &chunkSizer{sizes: sizes}, nil
+}
+
+func (s *chunkSizer) Next() int {
+	if len(s.sizes) == 0 {
+		return s.min + rand.Intn(s.max-s.min+1)
+	}
+	size := s.sizes[s.next]
+	if s.next < len(s.sizes)-1 {
+		s.next++
+	}
+	return size
+}
+
+// writeChunkedBody sends the body with the chunked transfer coding, followed by the trailer section.
+func (c *RawClient) writeChunkedBody(w io.Writer, body *common.Body) (*common.BodyDigest, error) {
+	opts := c.options.Chunked
+	if !opts.Enabled {
+		// the body of unknown size is chunked without '--chunked', the chunk shaping options do not apply to it
+		opts = ChunkedOptions{}
+	}
+	bodyDigest := common.NewBodyDigest()
+	sizer, err := newChunkSizer(opts.Sizes)
+	if err != nil {
+		return bodyDigest, err
+	}
+	bodyReader, err := body.Open()
+	if err != nil {
+		return bodyDigest, fmt.Errorf("error opening request body: %v", err)
+	}
+	defer common.SafeClose(bodyReader)
+	extension := ""
+	if opts.Extension != "" {
+		extension = ";" + opts.Extension
+	}
+	buf := make([]byte, 0)
+	for index := 0; ; index++ {
+		size := sizer.Next()
+		if cap(buf) < size {
+			buf = make([]byte, size)
+		}
+		var n int
+		if opts.Enabled {
+			n, err = io.ReadFull(bodyReader, buf[:size])
+		} else {
+			// the body of unknown size is chunked without '--chunked', so it is forwarded as it arrives
+			if n, err = bodyReader.Read(buf[:size]); n == 0 && err == nil {
+				continue
+			}
+		}
+		if n == 0 {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return bodyDigest, fmt.Errorf("error reading request body: %v", err)
+		}
+		if index > 0 && opts.Delay > 0 {
+			time.Sleep(opts.Delay)
+		}
+		declaredSize := n
+		if index == 0 && opts.malformed(ChunkedMalformWrongSize) {
+			declaredSize++
+		}
+		sizeLine := strconv.FormatInt(int64(declaredSize), 16)
+		if opts.malformed(ChunkedMalformInvalidHex) {
+			sizeLine = "0x" + sizeLine
+		}
+		c.reqPrintln(w, sizeLine+extension)
+		chunk := buf[:n]
+		bodyDigest.Write(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			return bodyDigest, fmt.Errorf("error sending request chunk: %w", err)
+		}
+		if c.verbose {
+			if n <= maxVerboseBodySize {
+				log.Printf("> %s\n", chunk)
+			} else {
+				log.Printf("> [chunk %d, %s]\n", index, common.PrittyByteSize(n))
+			}
+		}
+		c.reqPrintln(w, "")
+		if err != nil {
+			break // the body ended inside this chunk
+		}
+	}
+	if !opts.malformed(ChunkedMalformNoFinalChunk) {
+		c.reqPrintln(w, "0"+extension)
+		for _, trailer := range opts.Trailers {
+			c.reqPrintln(w, trailer)
+		}
+		c.reqPrintln(w, "")
+	}
+	return bodyDigest, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -3,5 +3,5 @@ package client
 import "rawh/common"
 
 type Client interface {
-	DoRequest(method string, urlString string, customHeaders common.MultiString, data string) error
+	DoRequest(method string, urlString string, customHeaders common.MultiString, body *common.Body) error
 }
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"rawh/common"
+	"strings"
+	"time"
+)
+
+const (
+	OutputFormatText = "text"
+	OutputFormatJson = "json"
+)
+
+// Exchange describes a single request and response, it is the document emitted by the 'json' output format.
+type Exchange struct {
+	Request    ExchangeRequest    `json:"request"`
+	Response   *ExchangeResponse  `json:"response,omitempty"`
+	Timings    ExchangeTimings    `json:"timings"`
+	Expect     *ExchangeExpect    `json:"expect,omitempty"`
+	Interim    []ExchangeInterim  `json:"interim,omitempty"`
+	Sse        *ExchangeSse       `json:"sse,omitempty"`
+	WebSocket  *ExchangeWebSocket `json:"webSocket,omitempty"`
+	Proxy      *ExchangeProxy     `json:"proxy,omitempty"`
+	Connection ExchangeConnection `json:"connection"`
+	Error      string             `json:"error,omitempty"`
+	start      time.Time
+	// requestBody is kept for the HAR export, which includes the sent body content
+	requestBody *common.Body
+}
+
+type ExchangeRequest struct {
+	Method       string   `json:"method"`
+	URL          string   `json:"url"`
+	StartLine    string   `json:"startLine"`
+	HeaderLines  []string `json:"headerLines"`
+	TrailerLines []string `json:"trailerLines,omitempty"`
+	Body         BodyInfo `json:"body"`
+}
+
+type ExchangeResponse struct {
+	StatusLine   string   `json:"statusLine"`
+	StatusCode   int      `json:"statusCode"`
+	HeaderLines  []string `json:"headerLines"`
+	TrailerLines []string `json:"trailerLines,omitempty"`
+	Body         BodyInfo `json:"body"`
+}
+
+// ExchangeTimings are measured in milliseconds from the start of the exchange.
+type ExchangeTimings struct {
+	Start       time.Time `json:"start"`
+	ConnectMs   float64   `json:"connectMs"`
+	TlsMs       float64   `json:"tlsHandshakeMs,omitempty"`
+	RequestMs   float64   `json:"requestSentMs"`
+	FirstByteMs float64   `json:"firstByteMs"`
+	TotalMs     float64   `json:"totalMs"`
+}
+
+type ExchangeConnection struct {
+	LocalAddress  string   `json:"localAddress,omitempty"`
+	RemoteAddress string   `json:"remoteAddress,omitempty"`
+	Reused        bool     `json:"reused,omitempty"`
+	Tls           *TlsInfo `json:"tls,omitempty"`
+}
+
+type TlsInfo struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipherSuite"`
+	NegotiatedProtocol string   `json:"alpn,omitempty"`
+	ServerName         string   `json:"serverName,omitempty"`
+	DidResume          bool     `json:"resumed"`
+	PeerCertificates   []string `json:"peerCertificates,omitempty"`
+}
+
+// BodyInfo describes a body as it was sent or received.
+type BodyInfo struct {
+	Size          int64  `json:"size"`
+	Hash          string `json:"hash"`
+	Decoding      string `json:"decoding,omitempty"`
+	DecodedSize   int64  `json:"decodedSize,omitempty"`
+	DecodedHash   string `json:"decodedHash,omitempty"`
+	DecodingError string `json:"decodingError,omitempty"`
+	OutputFile    string `json:"outputFile,omitempty"`
+	Content       string `json:"content,omitempty"`
+	ContentBase64 string `json:"contentBase64,omitempty"`
+}
+
+func newExchange(method string, url string) *Exchange {
+	start := time.Now()
+	return &Exchange{
+		Request: ExchangeRequest{Method: method, URL: url, HeaderLines: []string{}},
+		Timings: ExchangeTimings{Start: start},
+		start:   start,
+	}
+}
+
+// since returns the milliseconds elapsed from the start of the exchange.
+func (e *Exchange) since() float64 {
+	return float64(time.Since(e.start).Microseconds()) / 1000
+}
+
+func (e *Exchange) setConnection(conn net.Conn) {
+	e.Connection.LocalAddress = conn.LocalAddr().String()
+	e.Connection.RemoteAddress = conn.RemoteAddr().String()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		e.Connection.Tls = newTlsInfo(tlsConn.ConnectionState())
+	}
+}
+
+func newTlsInfo(state tls.ConnectionState) *TlsInfo {
+	info := &TlsInfo{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		DidResume:          state.DidResume,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, cert.Subject.String())
+	}
+	return info
+}
+
+func newBodyInfo(digest *common.BodyDigest) BodyInfo {
+	return BodyInfo{Size: digest.Size(), Hash: digest.Hash()}
+}
+
+// log reports the body in the format the server uses to describe the received body.
+func (b *BodyInfo) log(name string) {
+	log.Printf("# %s-body-size: %d\n", name, b.Size)
+	log.Printf("# %s-body-hash: %s\n", name, b.Hash)
+	if b.Decoding != "" {
+		log.Printf("# %s-body-decoding: %s\n", name, b.Decoding)
+		log.Printf("# %s-body-decoded-size: %d\n", name, b.DecodedSize)
+		log.Printf("# %s-body-decoded-hash: %s\n", name, b.DecodedHash)
+	}
+	if b.DecodingError != "" {
+		log.Printf("# %s-body-decoding-error: %s\n", name, b.DecodingError)
+	}
+	if b.OutputFile != "" {
+		log.Printf("# %s-body-output: %s\n", name, b.OutputFile)
+	}
+}
+
+// emitExchange writes the exchange document to the standard output, one document per line, and records it in the HAR log.
+func (o *Options) emitExchange(exchange *Exchange) {
+	exchange.Timings.TotalMs = exchange.since()
+	o.Har.Add(exchange)
+	if o.OutputFormat != OutputFormatJson {
+		return
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(exchange); err != nil {
+		log.Printf("Error encoding exchange: %v", err)
+	}
+}
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case "", OutputFormatText, OutputFormatJson:
+		return nil
+	}
+	return fmt.Errorf("unsupported output format: %s (options: %s)", format, strings.Join([]string{OutputFormatText, OutputFormatJson}, ", "))
+}
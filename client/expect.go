@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"rawh/common"
+	"strings"
+	"time"
+)
+
+const (
+	ExpectOutcomeContinue      = "continue"
+	ExpectOutcomeTimeout       = "timeout"
+	ExpectOutcomeFinalResponse = "final-response"
+)
+
+// ExpectOptions make the client send 'Expect: 100-continue' and wait for the interim response before sending the body.
+type ExpectOptions struct {
+	Continue bool
+	// Timeout is how long the client waits for '100 Continue' before it sends the body anyway, as curl does.
+	Timeout time.Duration
+}
+
+func (o *ExpectOptions) Validate() error {
+	if o.Timeout < 0 {
+		return fmt.Errorf("invalid expect timeout: %s", o.Timeout)
+	}
+	return nil
+}
+
+// ExchangeExpect reports whether and when the '100 Continue' arrived, a final response received instead stops the body.
+type ExchangeExpect struct {
+	Outcome    string  `json:"outcome"`
+	ContinueMs float64 `json:"continueMs,omitempty"`
+	WaitedMs   float64 `json:"waitedMs"`
+}
+
+// expectsContinue reports whether the request waits for '100 Continue', the Expect header may also be set by the user.
+func expectsContinue(headerLines []string, body *common.Body) bool {
+	if body.IsEmpty() {
+		return false
+	}
+	for _, line := range headerLines {
+		name, value, err := common.SplitHeaderLine(line)
+		if err == nil && strings.EqualFold(strings.TrimSpace(name), "Expect") && strings.EqualFold(strings.TrimSpace(value), "100-continue") {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitContinue waits for the interim response after the request head was sent, the final response is returned
+// when the server answered without '100 Continue', then the body must not be sent.
+func (c *RawClient) awaitContinue(conn net.Conn, reader *bufio.Reader, exchange *Exchange) (*rawResponse, error) {
+	waitStart := exchange.since()
+	timeout := c.options.Expect.Timeout
+	exchange.Expect = &ExchangeExpect{Outcome: ExpectOutcomeTimeout}
+	defer func() {
+		exchange.Expect.WaitedMs = exchange.since() - waitStart
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	if _, err := reader.Peek(1); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			log.Printf("# no 100 Continue within %s, sending the body\n", timeout)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error waiting for 100 Continue: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	resp, err := c.readResponseHead(reader)
+	for err == nil && resp.interim() && resp.statusCode != 100 {
+		c.recordInterim(exchange, resp) // e.g. 103 Early Hints sent before 100 Continue
+		resp, err = c.readResponseHead(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.statusCode == 100 {
+		c.recordInterim(exchange, resp)
+		exchange.Expect.Outcome = ExpectOutcomeContinue
+		exchange.Expect.ContinueMs = exchange.since()
+		log.Printf("# 100 Continue received after %.1fms, sending the body\n", exchange.Expect.ContinueMs-waitStart)
+		return nil, nil
+	}
+	exchange.Expect.Outcome = ExpectOutcomeFinalResponse
+	log.Printf("# '%s' received instead of 100 Continue, the body is not sent\n", resp.statusLine)
+	return resp, nil
+}
@@ -0,0 +1,383 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const HarVersion = "1.2"
+
+// Har is the HTTP Archive document, see http://www.softwareishard.com/blog/har-12-spec/
+type Har struct {
+	Log HarLog `json:"log"`
+}
+
+type HarLog struct {
+	Version string     `json:"version"`
+	Creator HarCreator `json:"creator"`
+	Entries []HarEntry `json:"entries"`
+}
+
+type HarCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HarEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HarRequest  `json:"request"`
+	Response        HarResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HarTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type HarRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	QueryString []HarNameValue `json:"queryString"`
+	PostData    *HarPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HarResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HttpVersion string         `json:"httpVersion"`
+	Cookies     []HarCookie    `json:"cookies"`
+	Headers     []HarNameValue `json:"headers"`
+	Content     HarContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type HarNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HarCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	HttpOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type HarPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type HarContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// HarTimings are in milliseconds, -1 marks the phases which do not apply.
+type HarTimings struct {
+	Blocked float64 `json:"blocked"`
+	Dns     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	Ssl     float64 `json:"ssl"`
+}
+
+// HarRecorder collects the exchanges of the client and writes them as a HAR log.
+type HarRecorder struct {
+	path    string
+	creator HarCreator
+	entries []HarEntry
+}
+
+func NewHarRecorder(path string, creatorName string, creatorVersion string) *HarRecorder {
+	return &HarRecorder{path: path, creator: HarCreator{Name: creatorName, Version: creatorVersion}, entries: []HarEntry{}}
+}
+
+// Add records the exchange, it does nothing when the HAR export is disabled.
+func (r *HarRecorder) Add(exchange *Exchange) {
+	if r != nil {
+		r.entries = append(r.entries, newHarEntry(exchange))
+	}
+}
+
+// Write saves the recorded exchanges to the file, it does nothing when the HAR export is disabled.
+func (r *HarRecorder) Write() error {
+	if r == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(Har{Log: HarLog{Version: HarVersion, Creator: r.creator, Entries: r.entries}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding HAR: %v", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing HAR file: %v", err)
+	}
+	log.Printf("# har-output: %s (%d entries)\n", r.path, len(r.entries))
+	return nil
+}
+
+func newHarEntry(exchange *Exchange) HarEntry {
+	entry := HarEntry{
+		StartedDateTime: exchange.Timings.Start,
+		Time:            exchange.Timings.TotalMs,
+		Request:         newHarRequest(exchange),
+		Response:        newHarResponse(exchange.Response),
+		Timings:         newHarTimings(exchange),
+		Comment:         exchange.Error,
+	}
+	if host, _, err := net.SplitHostPort(exchange.Connection.RemoteAddress); err == nil {
+		entry.ServerIPAddress = host
+	}
+	if _, port, err := net.SplitHostPort(exchange.Connection.LocalAddress); err == nil {
+		entry.Connection = port
+	}
+	return entry
+}
+
+func newHarRequest(exchange *Exchange) HarRequest {
+	request := HarRequest{
+		Method:      exchange.Request.Method,
+		URL:         exchange.Request.URL,
+		HttpVersion: lastField(exchange.Request.StartLine),
+		Headers:     harHeaders(exchange.Request.HeaderLines),
+		Cookies:     []HarCookie{},
+		QueryString: []HarNameValue{},
+		HeadersSize: harHeadersSize(exchange.Request.StartLine, exchange.Request.HeaderLines),
+		BodySize:    exchange.Request.Body.Size,
+	}
+	header := harHttpHeader(request.Headers)
+	for _, cookie := range (&http.Request{Header: header}).Cookies() {
+		request.Cookies = append(request.Cookies, HarCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	if parsedURL, err := url.Parse(exchange.Request.URL); err == nil {
+		for _, pair := range strings.Split(parsedURL.RawQuery, "&") {
+			if pair == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(pair, "=")
+			name, _ = url.QueryUnescape(name)
+			value, _ = url.QueryUnescape(value)
+			request.QueryString = append(request.QueryString, HarNameValue{Name: name, Value: value})
+		}
+	}
+	if !exchange.requestBody.IsEmpty() {
+		request.PostData = &HarPostData{MimeType: header.Get("Content-Type"), Text: harBodyText(exchange.requestBody)}
+	}
+	return request
+}
+
+// harBodyText returns the sent body content, HAR keeps only the text bodies of a known size which are not too large.
+func harBodyText(body *common.Body) string {
+	if !body.SizeKnown() || body.Size > maxCapturedContent {
+		return "" // the standard input cannot be read again
+	}
+	reader, err := body.Open()
+	if err != nil {
+		return ""
+	}
+	defer common.SafeClose(reader)
+	data, err := io.ReadAll(reader)
+	if err != nil || !utf8.Valid(data) {
+		return ""
+	}
+	return string(data)
+}
+
+func newHarResponse(exchangeResponse *ExchangeResponse) HarResponse {
+	response := HarResponse{
+		Cookies:     []HarCookie{},
+		Headers:     []HarNameValue{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if exchangeResponse == nil {
+		return response // no response was received, the entry comment holds the error
+	}
+	httpVersion, status, _ := strings.Cut(exchangeResponse.StatusLine, " ")
+	_, response.StatusText, _ = strings.Cut(status, " ")
+	response.Status = exchangeResponse.StatusCode
+	response.HttpVersion = httpVersion
+	response.Headers = harHeaders(exchangeResponse.HeaderLines)
+	response.HeadersSize = harHeadersSize(exchangeResponse.StatusLine, exchangeResponse.HeaderLines)
+	body := exchangeResponse.Body
+	response.BodySize = body.Size
+	header := harHttpHeader(response.Headers)
+	response.RedirectURL = header.Get("Location")
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		response.Cookies = append(response.Cookies, HarCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HttpOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		})
+	}
+	response.Content = HarContent{Size: body.Size, MimeType: header.Get("Content-Type"), Text: body.Content}
+	if body.Decoding != "" {
+		response.Content.Size = body.DecodedSize
+		response.Content.Compression = body.DecodedSize - body.Size
+	}
+	if body.ContentBase64 != "" {
+		response.Content.Text, response.Content.Encoding = body.ContentBase64, "base64"
+	}
+	return response
+}
+
+// newHarTimings splits the exchange timings into the HAR phases, the connect phase includes the TLS handshake.
+func newHarTimings(exchange *Exchange) HarTimings {
+	t := exchange.Timings
+	timings := HarTimings{Blocked: -1, Dns: -1, Connect: -1, Ssl: -1}
+	connected := 0.0
+	if !exchange.Connection.Reused {
+		connected = t.ConnectMs + t.TlsMs
+		timings.Connect = connected
+		if t.TlsMs > 0 {
+			timings.Ssl = t.TlsMs
+		}
+	}
+	timings.Send = max(t.RequestMs-connected, 0)
+	timings.Wait = max(t.FirstByteMs-t.RequestMs, 0)
+	timings.Receive = max(t.TotalMs-max(t.FirstByteMs, t.RequestMs), 0)
+	return timings
+}
+
+func harHeaders(lines []string) []HarNameValue {
+	headers := []HarNameValue{}
+	for _, line := range lines {
+		if name, value, err := common.SplitHeaderLine(line); err == nil {
+			headers = append(headers, HarNameValue{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		}
+	}
+	return headers
+}
+
+func harHttpHeader(headers []HarNameValue) http.Header {
+	header := http.Header{}
+	for _, h := range headers {
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+// harHeadersSize counts the bytes of the start line and the header section with its terminating empty line.
+func harHeadersSize(startLine string, headerLines []string) int {
+	size := len(startLine) + 4
+	for _, line := range headerLines {
+		size += len(line) + 2
+	}
+	return size
+}
+
+func lastField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// HarReplayRequest is a request recorded in a HAR file, prepared to be sent again.
+type HarReplayRequest struct {
+	Entry   int
+	Method  string
+	URL     string
+	Headers common.MultiString
+	Body    *common.Body
+}
+
+// ReadHarRequests loads the requests of the selected entries (numbered from 1, all when none is selected) from the HAR file.
+// When the target is set, its scheme and host replace the recorded ones, the recorded headers are kept as they are.
+func ReadHarRequests(path string, entries []int, target string) ([]HarReplayRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading HAR file: %v", err)
+	}
+	var har Har
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("error parsing HAR file: %v", err)
+	}
+	var targetURL *url.URL
+	if target != "" {
+		if targetURL, err = url.Parse(target); err != nil {
+			return nil, fmt.Errorf("error parsing replay target: %v", err)
+		}
+	}
+	if len(entries) == 0 {
+		for i := range har.Log.Entries {
+			entries = append(entries, i+1)
+		}
+	}
+	var requests []HarReplayRequest
+	for _, entry := range entries {
+		if entry < 1 || entry > len(har.Log.Entries) {
+			return nil, fmt.Errorf("HAR entry %d not found, the file has %d entries", entry, len(har.Log.Entries))
+		}
+		request, err := newHarReplayRequest(entry, har.Log.Entries[entry-1].Request, targetURL)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func newHarReplayRequest(entry int, harRequest HarRequest, target *url.URL) (HarReplayRequest, error) {
+	requestURL, err := url.Parse(harRequest.URL)
+	if err != nil {
+		return HarReplayRequest{}, fmt.Errorf("error parsing HAR entry %d URL: %v", entry, err)
+	}
+	if target != nil {
+		requestURL.Scheme, requestURL.Host = target.Scheme, target.Host
+	}
+	request := HarReplayRequest{Entry: entry, Method: harRequest.Method, URL: requestURL.String(), Body: common.NewEmptyBody()}
+	if harRequest.PostData != nil {
+		request.Body = common.NewStringBody(harRequest.PostData.Text)
+		request.Body.Description = fmt.Sprintf("HAR entry %d post data", entry)
+	}
+	if harRequest.BodySize > 0 && request.Body.Size != harRequest.BodySize {
+		log.Printf("# har entry %d: recorded body size %d, sending %d bytes of the recorded post data\n", entry, harRequest.BodySize, request.Body.Size)
+	}
+	for _, header := range harRequest.Headers {
+		if strings.HasPrefix(header.Name, ":") {
+			continue // HTTP/2 pseudo-headers, the request line and Host carry them
+		}
+		if strings.EqualFold(header.Name, common.TransferEncodingHeaderName) {
+			log.Printf("# har entry %d: %s: %s dropped, the recorded post data is sent as a whole\n", entry, header.Name, header.Value)
+			continue
+		}
+		value := header.Value
+		if strings.EqualFold(header.Name, common.ContentLengthHeaderName) && value != strconv.FormatInt(request.Body.Size, 10) {
+			log.Printf("# har entry %d: %s %s replaced by %d\n", entry, header.Name, value, request.Body.Size)
+			value = strconv.FormatInt(request.Body.Size, 10)
+		}
+		request.Headers = append(request.Headers, header.Name+": "+value)
+	}
+	return request, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import "rawh/common"
+
+// Options are the request sending settings beyond the basic connection parameters.
+type Options struct {
+	Chunked  ChunkedOptions
+	Slow     SlowOptions
+	Response ResponseOptions
+	Redirect RedirectOptions
+	// Expect sends 'Expect: 100-continue' and waits for the interim response before the body.
+	Expect ExpectOptions
+	// ProxyProtocol sends the PROXY protocol header before the request when set.
+	ProxyProtocol ProxyProtocolOptions
+	// Proxy sends the requests through the upstream HTTP or SOCKS5 proxy.
+	Proxy ProxyOptions
+	// WebSocket performs the opening handshake and exchanges the frames after it (raw client).
+	WebSocket WebSocketOptions
+	// Sse requests the event stream and analyzes the delivery of its events.
+	Sse SseOptions
+	// OutputFormat is 'text' or 'json', the latter emits one exchange document per line.
+	OutputFormat string
+	// Display controls how the raw lines are shown in the verbose output of the raw client.
+	Display common.Display
+	// Har records the exchanges for the HAR export when set.
+	Har *HarRecorder
+}
+
+func (o *Options) Validate() error {
+	if err := o.Chunked.Validate(); err != nil {
+		return err
+	}
+	if err := o.Slow.Validate(); err != nil {
+		return err
+	}
+	if err := o.Response.Validate(); err != nil {
+		return err
+	}
+	if err := o.Redirect.Validate(); err != nil {
+		return err
+	}
+	if err := o.Expect.Validate(); err != nil {
+		return err
+	}
+	if err := o.ProxyProtocol.Validate(); err != nil {
+		return err
+	}
+	if err := o.Proxy.Validate(); err != nil {
+		return err
+	}
+	if err := o.Sse.Validate(); err != nil {
+		return err
+	}
+	if err := o.WebSocket.Validate(); err != nil {
+		return err
+	}
+	return validateOutputFormat(o.OutputFormat)
+}
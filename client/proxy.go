@@ -0,0 +1,271 @@
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"rawh/common"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+const (
+	ProxyModeAuto     = "auto"
+	ProxyModeAbsolute = "absolute"
+	ProxyModeConnect  = "connect"
+)
+
+// ProxyOptions send the requests through the upstream proxy: an HTTP proxy gets the absolute-form request or the CONNECT
+// request of the tunnel, a SOCKS5 proxy connects to the target. The credentials of the proxy URL are sent to the proxy.
+type ProxyOptions struct {
+	// URL is the 'http://', 'https://' or 'socks5://' proxy URL, empty connects directly.
+	URL string
+	// Mode is 'auto' - the absolute-form for the http and CONNECT for the https and WebSocket requests, 'absolute' or 'connect'.
+	Mode string
+	// Headers are sent to the HTTP proxy in the CONNECT request or with the absolute-form request, format 'Key: value' (exact case).
+	Headers []string
+}
+
+func (o *ProxyOptions) Validate() error {
+	if o.URL == "" {
+		if len(o.Headers) > 0 {
+			return fmt.Errorf("proxy headers require the proxy URL")
+		}
+		return nil
+	}
+	proxyURL, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL '%s': %v", o.URL, err)
+	}
+	if proxyURL.Hostname() == "" {
+		return fmt.Errorf("invalid proxy URL '%s': missing host", o.URL)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		if o.Mode != ProxyModeAuto || len(o.Headers) > 0 {
+			return fmt.Errorf("the proxy mode and headers apply to HTTP proxies, not to '%s'", proxyURL.Scheme)
+		}
+	default:
+		return fmt.Errorf("unsupported proxy scheme '%s' (options: http, https, socks5)", proxyURL.Scheme)
+	}
+	switch o.Mode {
+	case ProxyModeAuto, ProxyModeAbsolute, ProxyModeConnect:
+	default:
+		return fmt.Errorf("invalid proxy mode '%s' (options: %s, %s, %s)", o.Mode, ProxyModeAuto, ProxyModeAbsolute, ProxyModeConnect)
+	}
+	for _, line := range o.Headers {
+		if _, _, err := common.SplitHeaderLine(line); err != nil {
+			return fmt.Errorf("invalid proxy header '%s': %v", line, err)
+		}
+	}
+	return nil
+}
+
+// proxyURL returns the validated proxy URL, nil when the connections are direct.
+func (o *ProxyOptions) proxyURL() *url.URL {
+	if o.URL == "" {
+		return nil
+	}
+	proxyURL, _ := url.Parse(o.URL)
+	return proxyURL
+}
+
+func (o *ProxyOptions) socks() bool {
+	proxyURL := o.proxyURL()
+	return proxyURL != nil && strings.HasPrefix(proxyURL.Scheme, "socks5")
+}
+
+// headerLines returns the headers sent to the HTTP proxy, the Proxy-Authorization of the URL credentials goes first
+// unless it was given explicitly.
+func (o *ProxyOptions) headerLines() []string {
+	var lines []string
+	proxyURL := o.proxyURL()
+	if proxyURL.User != nil && !headerLinesHave(o.Headers, "Proxy-Authorization") {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		lines = append(lines, "Proxy-Authorization: Basic "+credentials)
+	}
+	return append(lines, o.Headers...)
+}
+
+func headerLinesHave(lines []string, headerName string) bool {
+	for _, line := range lines {
+		if name, _, err := common.SplitHeaderLine(line); err == nil && strings.EqualFold(name, headerName) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExchangeProxy describes the upstream proxy of the exchange, the URL is shown without the password.
+type ExchangeProxy struct {
+	URL     string           `json:"url"`
+	Mode    string           `json:"mode"`
+	Connect *ExchangeConnect `json:"connect,omitempty"`
+}
+
+// ExchangeConnect is the CONNECT exchange opening the tunnel through the HTTP proxy.
+type ExchangeConnect struct {
+	RequestLines []string `json:"requestLines"`
+	StatusLine   string   `json:"statusLine,omitempty"`
+	StatusCode   int      `json:"statusCode,omitempty"`
+	HeaderLines  []string `json:"headerLines,omitempty"`
+}
+
+// absoluteForm reports whether the request goes to the HTTP proxy in the absolute-form instead of a tunnel.
+func (c *RawClient) absoluteForm(parsedURL *url.URL) bool {
+	o := c.options.Proxy
+	if o.URL == "" || o.socks() {
+		return false
+	}
+	switch o.Mode {
+	case ProxyModeAbsolute:
+		return true
+	case ProxyModeConnect:
+		return false
+	}
+	return parsedURL.Scheme == "http" && !c.options.WebSocket.Enabled
+}
+
+// requestTarget returns the request target of the start line, the absolute URL without the credentials and the fragment
+// when the request goes to the HTTP proxy.
+func (c *RawClient) requestTarget(parsedURL *url.URL) string {
+	if c.absoluteForm(parsedURL) {
+		return parsedURL.Scheme + "://" + parsedURL.Host + parsedURL.RequestURI()
+	}
+	return parsedURL.RequestURI()
+}
+
+// connect opens the TCP connection to the URL host, directly, through the SOCKS5 proxy or the tunnel of the HTTP proxy;
+// the connection of the absolute-form requests goes to the HTTP proxy.
+func (c *RawClient) connect(parsedURL *url.URL, exchange *Exchange) (net.Conn, error) {
+	proxyURL := c.options.Proxy.proxyURL()
+	if proxyURL == nil {
+		return net.Dial("tcp", hostPort(parsedURL))
+	}
+	exchange.Proxy = &ExchangeProxy{URL: proxyURL.Redacted()}
+	if c.options.Proxy.socks() {
+		exchange.Proxy.Mode = proxyURL.Scheme
+		dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		if !c.jsonOutput() {
+			log.Printf("# proxy: %s to %s\n", exchange.Proxy.URL, hostPort(parsedURL))
+		}
+		return dialer.Dial("tcp", hostPort(parsedURL))
+	}
+	conn, err := c.dialProxy(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if c.absoluteForm(parsedURL) {
+		exchange.Proxy.Mode = ProxyModeAbsolute
+		if !c.jsonOutput() {
+			log.Printf("# proxy: %s with the absolute-form request\n", exchange.Proxy.URL)
+		}
+		return conn, nil
+	}
+	exchange.Proxy.Mode = ProxyModeConnect
+	if !c.jsonOutput() {
+		log.Printf("# proxy: %s with the CONNECT tunnel\n", exchange.Proxy.URL)
+	}
+	tunnel, err := c.openTunnel(conn, hostPort(parsedURL), exchange.Proxy)
+	if err != nil {
+		common.SafeClose(conn)
+		return nil, err
+	}
+	return tunnel, nil
+}
+
+// dialProxy connects to the HTTP proxy, the TLS handshake is done with the https proxy.
+func (c *RawClient) dialProxy(proxyURL *url.URL) (net.Conn, error) {
+	port := proxyURL.Port()
+	if port == "" {
+		port = "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(proxyURL.Hostname(), port))
+	if err != nil || proxyURL.Scheme != "https" {
+		return conn, err
+	}
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.ServerName = proxyURL.Hostname()
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		common.SafeClose(conn)
+		return nil, fmt.Errorf("error establishing secure connection to the proxy: %v", err)
+	}
+	return tlsConn, nil
+}
+
+// openTunnel sends the CONNECT request and reads the proxy response, both shown verbatim;
+// the tunnel is open when the proxy responds with 2xx.
+func (c *RawClient) openTunnel(conn net.Conn, authority string, exchangeProxy *ExchangeProxy) (net.Conn, error) {
+	connect := &ExchangeConnect{RequestLines: []string{"CONNECT " + authority + " HTTP/1.1"}}
+	exchangeProxy.Connect = connect
+	if !headerLinesHave(c.options.Proxy.Headers, "Host") {
+		connect.RequestLines = append(connect.RequestLines, "Host: "+authority)
+	}
+	connect.RequestLines = append(connect.RequestLines, c.options.Proxy.headerLines()...)
+	var head strings.Builder
+	for _, line := range connect.RequestLines {
+		head.WriteString(line + "\r\n")
+		c.proxyLine(">", line+"\r\n")
+	}
+	head.WriteString("\r\n")
+	c.proxyLine(">", "\r\n")
+	if _, err := conn.Write([]byte(head.String())); err != nil {
+		return nil, fmt.Errorf("error sending CONNECT request: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := c.readResponseHead(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CONNECT response: %v", err)
+	}
+	connect.StatusLine, connect.StatusCode = resp.statusLine, resp.statusCode
+	for _, field := range resp.headers.Fields {
+		connect.HeaderLines = append(connect.HeaderLines, field.Name+": "+field.Value)
+	}
+	if !c.verbose && !c.jsonOutput() {
+		log.Printf("< %s\n", connect.StatusLine)
+		for _, line := range connect.HeaderLines {
+			log.Printf("< %s\n", line)
+		}
+	}
+	if resp.statusCode < http.StatusOK || resp.statusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("the proxy refused the tunnel: %s", connect.StatusLine)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// proxyLine shows the sent line of the CONNECT request, it is shown without the verbose output too.
+func (c *RawClient) proxyLine(direction string, line string) {
+	if c.verbose {
+		c.lineVerbose(direction, line)
+	} else if !c.jsonOutput() {
+		log.Printf("%s %s\n", direction, strings.TrimSpace(line))
+	}
+}
+
+// bufferedConn reads the bytes the proxy sent after its CONNECT response before reading the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
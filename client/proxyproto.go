@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"rawh/common"
+	"strings"
+)
+
+// ProxyProtocolOptions make the client send the PROXY protocol header before the request, as a load balancer does.
+type ProxyProtocolOptions struct {
+	// Version is 1 or 2, 0 sends no header.
+	Version int
+	// Source is the client address claimed by the header, the local address of the connection when empty.
+	Source string
+	// TLVs are sent by the version 2 header only, as 'type=value' like 'authority=example.com' or '0xe0=value'.
+	TLVs []string
+}
+
+func (o *ProxyProtocolOptions) Validate() error {
+	if o.Version < 0 || o.Version > 2 {
+		return fmt.Errorf("invalid PROXY protocol version: %d", o.Version)
+	}
+	if o.Source != "" {
+		if _, err := netip.ParseAddrPort(o.Source); err != nil {
+			return fmt.Errorf("invalid PROXY protocol source '%s', expected 'ip:port': %v", o.Source, err)
+		}
+	}
+	if len(o.TLVs) > 0 && o.Version != 2 {
+		return fmt.Errorf("PROXY protocol TLVs require the version 2 header")
+	}
+	for _, spec := range o.TLVs {
+		if _, err := common.ParseProxyTLV(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendProxyHeader writes the PROXY protocol header to the new connection, before the TLS handshake.
+func (c *RawClient) sendProxyHeader(conn net.Conn) error {
+	o := c.options.ProxyProtocol
+	if o.Version == 0 {
+		return nil
+	}
+	source, err := common.AddrPort(conn.LocalAddr())
+	if err != nil {
+		return err
+	}
+	if o.Source != "" {
+		source = netip.MustParseAddrPort(o.Source)
+	}
+	destination, err := common.AddrPort(conn.RemoteAddr())
+	if err != nil {
+		return err
+	}
+	var tlvs []common.ProxyTLV
+	for _, spec := range o.TLVs {
+		tlv, _ := common.ParseProxyTLV(spec) // validated by the options
+		tlvs = append(tlvs, tlv)
+	}
+	header, err := common.EncodeProxyHeader(o.Version, source, destination, tlvs)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("error sending PROXY header: %v", err)
+	}
+	if !c.verbose {
+		return nil
+	}
+	if o.Version == 1 {
+		c.lineVerbose(">", string(header))
+		return nil
+	}
+	log.Printf("# PROXY v2 header: %s -> %s, %d bytes\n", source, destination, len(header))
+	for _, tlv := range tlvs {
+		log.Printf("# PROXY v2 TLV %s: %s\n", tlv.Name, tlv.Value)
+	}
+	for _, dumpLine := range (common.Display{HexDump: true}).HexDumpLines(string(header)) {
+		log.Printf("# %s\n", strings.TrimSpace(dumpLine))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"bufio"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"golang.org/x/net/http2"
 	"io"
@@ -12,17 +13,24 @@ import (
 	"net/url"
 	"rawh/common"
 	"strings"
+	"time"
 )
 
+const maxVerboseBodySize = 1024
+
 type RawClient struct {
 	verbose          bool
 	normalizeHeaders bool
 	httpVersion      common.HttpVersion
 	httpClient       *http.Client
 	tlsConfig        *tls.Config
+	options          Options
 }
 
-func NewRawClient(normalizeHeaders bool, tlsVersionName string, insecure bool, httpVersionName string, verbose bool) (Client, error) {
+func NewRawClient(normalizeHeaders bool, tlsVersionName string, insecure bool, httpVersionName string, verbose bool, options Options) (*RawClient, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 	tlsVer, err := common.ParseTlsVersionName(tlsVersionName)
 	if err != nil {
 		return nil, err
@@ -50,91 +58,359 @@ func NewRawClient(normalizeHeaders bool, tlsVersionName string, insecure bool, h
 		tlsConfig:        tlsConfig,
 		httpVersion:      httpVersion,
 		httpClient:       &http.Client{Transport: transport},
+		options:          options,
 	}, nil
 }
 
 func (c *RawClient) reqPrintln(w io.Writer, line string) {
 	line = strings.TrimSpace(line)
-	if c.verbose {
-		log.Printf("> %s\n", line)
-	}
 	_, err := fmt.Fprint(w, line+"\r\n")
+	if errors.Is(err, errRequestCut) {
+		return
+	}
+	c.lineVerbose(">", line+"\r\n")
 	if err != nil {
 		log.Printf("printing request line '%s' error: %v", line, err)
 	}
 }
+func (c *RawClient) pause(duration time.Duration, place string) {
+	if duration > 0 {
+		log.Printf("# pausing for %s %s\n", duration, place)
+		time.Sleep(duration)
+	}
+}
+
+// respVerbose shows the raw received line, trimmed unless the invisible characters are shown.
 func (c *RawClient) respVerbose(line string) {
+	c.lineVerbose("<", line)
+}
+
+func (c *RawClient) lineVerbose(direction string, line string) {
+	if c.verbose {
+		log.Printf("%s %s\n", direction, c.options.Display.Format(line))
+		if direction != "<" {
+			return // only the received lines are dumped
+		}
+		for _, dumpLine := range c.options.Display.HexDumpLines(line) {
+			log.Printf("# %s\n", dumpLine)
+		}
+	}
+}
+
+// framingHeaderLines returns the body framing headers which were not set explicitly by the user.
+func (c *RawClient) framingHeaderLines(reqHeaders *common.HttpHeaders, req *rawRequest) []string {
+	var lines []string
+	contentLength := !reqHeaders.Has(common.ContentLengthHeaderName)
+	if req.chunked {
+		if !reqHeaders.Has(common.TransferEncodingHeaderName) {
+			lines = append(lines, fmt.Sprintf("%s: %s", common.TransferEncodingHeaderName, "chunked"))
+		}
+		if c.options.Chunked.Enabled && len(c.options.Chunked.Trailers) > 0 && !reqHeaders.Has(common.TrailerHeaderName) {
+			lines = append(lines, fmt.Sprintf("%s: %s", common.TrailerHeaderName, c.options.Chunked.trailerNames()))
+		}
+		contentLength = contentLength && c.options.Chunked.Enabled && c.options.Chunked.malformed(ChunkedMalformClAndTe)
+	}
+	if contentLength && req.body.SizeKnown() {
+		lines = append(lines, fmt.Sprintf("%s: %s", common.ContentLengthHeaderName, fmt.Sprint(req.body.Size)))
+	}
+	return lines
+}
+
+// writeBody streams the body to the connection, only small bodies are shown in the verbose output.
+func (c *RawClient) writeBody(w io.Writer, body *common.Body) (*common.BodyDigest, error) {
+	bodyDigest := common.NewBodyDigest()
+	if body.IsEmpty() {
+		return bodyDigest, nil
+	}
+	bodyReader, err := body.Open()
+	if err != nil {
+		return bodyDigest, fmt.Errorf("error opening request body: %v", err)
+	}
+	defer common.SafeClose(bodyReader)
+	var preview strings.Builder
+	writers := []io.Writer{w, bodyDigest}
+	if c.verbose && body.SizeKnown() && body.Size <= maxVerboseBodySize {
+		writers = append(writers, &preview)
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), bodyReader)
+	if err != nil {
+		return bodyDigest, fmt.Errorf("error sending request body: %w", err)
+	}
 	if c.verbose {
-		line = strings.TrimSpace(line)
-		log.Printf("< %s\n", line)
+		if !body.SizeKnown() {
+			log.Printf("> [%s]\n", body.Description)
+		} else if body.Size <= maxVerboseBodySize {
+			log.Printf("> %s\n", preview.String())
+		} else {
+			log.Printf("> [%s, %s]\n", body.Description, common.PrittyByteSize(int(body.Size)))
+		}
 	}
+	return bodyDigest, nil
+}
+
+// rawRequest is a single request sent by the raw client, a redirect creates a new one.
+type rawRequest struct {
+	method   string
+	url      *url.URL
+	headers  common.MultiString
+	body     *common.Body
+	chunked  bool
+	exchange *Exchange
 }
 
-func (c *RawClient) DoRequest(method string, urlString string, customHeaders common.MultiString, data string) error {
+func (c *RawClient) DoRequest(method string, urlString string, customHeaders common.MultiString, body *common.Body) error {
 	parsedURL, err := url.Parse(urlString)
 	if err != nil {
 		return fmt.Errorf("error parsing URL: %v", err)
 	}
-
-	var conn net.Conn
-	if parsedURL.Scheme == "https" {
-		conn, err = tls.Dial("tcp", parsedURL.Host, c.tlsConfig)
-		if err != nil {
-			return fmt.Errorf("error establishing secure connection: %v", err)
+	if c.options.WebSocket.Enabled {
+		// the WebSocket schemes use the HTTP connection
+		switch parsedURL.Scheme {
+		case "ws":
+			parsedURL.Scheme = "http"
+		case "wss":
+			parsedURL.Scheme = "https"
 		}
-	} else {
-		conn, err = net.Dial("tcp", parsedURL.Host)
-		if err != nil {
-			return fmt.Errorf("error establishing connection: %v", err)
+	}
+	// the body of unknown size, like the standard input, is streamed with the chunked transfer coding
+	if !body.SizeKnown() && !c.options.Chunked.Enabled && c.httpVersion.Major == 1 && c.httpVersion.Minor == 0 {
+		return fmt.Errorf("the %s body of unknown size is sent with the chunked transfer coding, which HTTP/1.0 does not have", body.Description)
+	}
+	req := &rawRequest{method: method, url: parsedURL, headers: customHeaders, body: body, chunked: c.options.Chunked.Enabled || !body.SizeKnown()}
+	for redirects := 0; ; redirects++ {
+		req.exchange = newExchange(req.method, req.url.String())
+		req.exchange.requestBody = req.body
+		resp, err := c.roundTrip(req)
+		if err != nil || resp == nil {
+			c.emit(req.exchange, err)
+			return err
 		}
+		if c.options.WebSocket.Enabled && resp.statusCode == http.StatusSwitchingProtocols {
+			err = c.runWebSocket(req.exchange, resp)
+			c.finishResponse(req.exchange, resp, newBodyInfo(common.NewBodyDigest()))
+			c.emit(req.exchange, err)
+			return err
+		}
+		var next *rawRequest
+		if c.options.Redirect.Follow && isRedirect(resp.statusCode) {
+			if redirects < c.options.Redirect.MaxRedirects {
+				next, err = nextRedirectRequest(req, resp, redirects+1)
+			} else {
+				log.Printf("# redirect limit %d reached, not following\n", c.options.Redirect.MaxRedirects)
+			}
+		}
+		var bodyInfo BodyInfo
+		if next == nil && err == nil {
+			monitor := newSseMonitor(req.exchange, c.options)
+			bodyInfo, err = processResponseBody(resp.body, resp.contentEncoding(), c.options, monitor)
+			monitor.finish()
+		} else if err == nil {
+			bodyInfo, err = discardResponseBody(resp.body)
+		}
+		c.finishResponse(req.exchange, resp, bodyInfo)
+		c.emit(req.exchange, err)
+		if next == nil || err != nil {
+			return err
+		}
+		req = next
+	}
+}
+
+func (c *RawClient) jsonOutput() bool {
+	return c.options.OutputFormat == OutputFormatJson
+}
+
+// emit writes the exchange document, the error is kept in the document and returned by the caller anyway.
+func (c *RawClient) emit(exchange *Exchange, err error) {
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	c.options.emitExchange(exchange)
+}
+
+// roundTrip sends the request on a new connection and reads the response head,
+// the response is nil when the connection was deliberately aborted while sending.
+func (c *RawClient) roundTrip(req *rawRequest) (*rawResponse, error) {
+	parsedURL, exchange := req.url, req.exchange
+	conn, err := c.dial(parsedURL, exchange)
+	if err != nil {
+		return nil, err
 	}
-	defer common.SafeClose(conn)
+	writer := newRequestWriter(conn, c.options.Slow)
 
 	// request:
 	reqHeaders := common.NewHttpHeaders(c.normalizeHeaders)
 	reqHeaders.Host = parsedURL.Host
-	err = reqHeaders.AddLines(customHeaders)
+	err = reqHeaders.AddLines(req.headers)
 	if err != nil {
-		return fmt.Errorf("error adding custom headers: %v", err)
-	}
-	c.reqPrintln(conn, fmt.Sprintf("%s %s %s", method, parsedURL.RequestURI(), c.httpVersion.Proto))
-	c.reqPrintln(conn, fmt.Sprintf("%s: %s", "Host", reqHeaders.Host))
-	for key, values := range reqHeaders.HeadersData {
-		if strings.ToLower(key) != "host" {
-			for _, value := range values {
-				c.reqPrintln(conn, fmt.Sprintf("%s: %s", key, value))
-			}
+		common.SafeClose(conn)
+		return nil, fmt.Errorf("error adding custom headers: %v", err)
+	}
+	// a Host header set by the user is sent in its position and case, otherwise it goes first
+	var headerLines []string
+	if !reqHeaders.Has("Host") {
+		headerLines = append(headerLines, fmt.Sprintf("%s: %s", "Host", reqHeaders.Host))
+	}
+	for _, field := range reqHeaders.Fields {
+		headerLines = append(headerLines, fmt.Sprintf("%s: %s", field.Name, field.Value))
+	}
+	if c.options.Sse.Enabled && !reqHeaders.Has("Accept") {
+		headerLines = append(headerLines, "Accept: "+sseContentType)
+	}
+	if c.options.Expect.Continue && !reqHeaders.Has("Expect") {
+		headerLines = append(headerLines, "Expect: 100-continue")
+	}
+	if c.options.WebSocket.Enabled {
+		headerLines = append(headerLines, c.options.WebSocket.webSocketHandshakeLines(reqHeaders)...)
+	}
+	if !c.options.WebSocket.Enabled || !req.body.IsEmpty() {
+		headerLines = append(headerLines, c.framingHeaderLines(reqHeaders, req)...)
+	}
+	if c.absoluteForm(parsedURL) {
+		headerLines = append(headerLines, c.options.Proxy.headerLines()...)
+	}
+	exchange.Request.StartLine = fmt.Sprintf("%s %s %s", req.method, c.requestTarget(parsedURL), c.httpVersion.Proto)
+	exchange.Request.HeaderLines = headerLines
+	c.reqPrintln(writer, exchange.Request.StartLine)
+	c.logHop(req, "> "+exchange.Request.StartLine)
+	c.pause(c.options.Slow.PauseAfterRequestLine, "after the request line")
+	for _, line := range headerLines {
+		c.reqPrintln(writer, line)
+	}
+	c.reqPrintln(writer, "")
+	c.pause(c.options.Slow.PauseAfterHeaders, "after the headers")
+	responseReader := bufio.NewReader(conn)
+	if expectsContinue(headerLines, req.body) && !writer.aborted {
+		resp, err := c.awaitContinue(conn, responseReader, exchange)
+		if err != nil {
+			common.SafeClose(conn)
+			return nil, err
 		}
+		if resp != nil {
+			exchange.Timings.FirstByteMs = exchange.since()
+			exchange.Request.Body = newBodyInfo(common.NewBodyDigest())
+			return c.startResponse(conn, responseReader, resp, req), nil
+		}
+	}
+	writer.startBody()
+	var bodyDigest *common.BodyDigest
+	if req.chunked {
+		bodyDigest, err = c.writeChunkedBody(writer, req.body)
+		exchange.Request.TrailerLines = c.options.Chunked.Trailers
+	} else {
+		bodyDigest, err = c.writeBody(writer, req.body)
 	}
-	c.reqPrintln(conn, fmt.Sprintf("%s: %s", common.ContentLengthHeaderName, fmt.Sprint(len(data))))
-	c.reqPrintln(conn, "")
-	if data != "" {
-		c.reqPrintln(conn, data)
+	exchange.Request.Body = newBodyInfo(bodyDigest)
+	if !c.jsonOutput() && !req.body.IsEmpty() {
+		exchange.Request.Body.log("request")
+	}
+	exchange.Timings.RequestMs = exchange.since()
+	if err != nil && !errors.Is(err, errRequestCut) {
+		log.Printf("%v, reading the response anyway", err) // the server may have responded early
+	}
+	if writer.aborted {
+		exchange.Error = "connection aborted while sending the request"
+		return nil, nil
 	}
 
 	// response:
-	responseReader := bufio.NewReader(conn)
-	responseStatus, err := responseReader.ReadString('\n')
+	resp, err := c.readResponse(conn, responseReader, exchange, req.method)
 	if err != nil {
-		return fmt.Errorf("error reading response status: %v", err)
+		return nil, err
 	}
-	c.respVerbose(responseStatus)
-	// Reading headers directly without normalization
-	for {
-		line, err := responseReader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("error reading headers: %v", err)
+	c.logHop(req, "< "+resp.statusLine)
+	return resp, nil
+}
+
+// startResponse prepares the body of the response read before the request was complete.
+func (c *RawClient) startResponse(conn net.Conn, reader *bufio.Reader, resp *rawResponse, req *rawRequest) *rawResponse {
+	resp.conn, resp.reader = conn, reader
+	resp.body = resp.bodyReader(reader, req.method)
+	c.logHop(req, "< "+resp.statusLine)
+	return resp
+}
+
+// dial opens the connection to the URL host, the TLS handshake is done for the https scheme
+// unless the absolute-form request goes to the HTTP proxy.
+func (c *RawClient) dial(parsedURL *url.URL, exchange *Exchange) (net.Conn, error) {
+	conn, err := c.connect(parsedURL, exchange)
+	if err != nil {
+		return nil, fmt.Errorf("error establishing connection: %v", err)
+	}
+	exchange.Timings.ConnectMs = exchange.since()
+	if err := c.sendProxyHeader(conn); err != nil {
+		common.SafeClose(conn)
+		return nil, err
+	}
+	if parsedURL.Scheme == "https" && !c.absoluteForm(parsedURL) {
+		tlsConfig := c.tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = parsedURL.Hostname()
 		}
-		line = strings.TrimSpace(line)
-		c.respVerbose(line)
-		if line == "" {
-			break // header section end
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			common.SafeClose(conn)
+			return nil, fmt.Errorf("error establishing secure connection: %v", err)
 		}
+		exchange.Timings.TlsMs = exchange.since() - exchange.Timings.ConnectMs
+		conn = tlsConn
 	}
-	body, err := io.ReadAll(responseReader)
+	exchange.setConnection(conn)
+	return conn, nil
+}
+
+// readResponse reads the response head from the connection, the connection is closed when it fails.
+func (c *RawClient) readResponse(conn net.Conn, responseReader *bufio.Reader, exchange *Exchange, method string) (*rawResponse, error) {
+	if _, err := responseReader.Peek(1); err == nil {
+		exchange.Timings.FirstByteMs = exchange.since()
+	}
+	resp, err := c.readFinalResponseHead(responseReader, exchange)
 	if err != nil {
-		return fmt.Errorf("error reading response: %v", err)
+		common.SafeClose(conn)
+		return nil, err
+	}
+	resp.conn, resp.reader = conn, responseReader
+	resp.body = resp.bodyReader(responseReader, method)
+	return resp, nil
+}
+
+// finishResponse records the consumed response in the exchange, shows its trailers and closes its connection.
+func (c *RawClient) finishResponse(exchange *Exchange, resp *rawResponse, bodyInfo BodyInfo) {
+	exchange.Response = &ExchangeResponse{
+		StatusLine:  resp.statusLine,
+		StatusCode:  resp.statusCode,
+		HeaderLines: []string{},
+		Body:        bodyInfo,
+	}
+	for _, field := range resp.headers.Fields {
+		exchange.Response.HeaderLines = append(exchange.Response.HeaderLines, field.Name+": "+field.Value)
+	}
+	if chunkedBody, ok := resp.body.(*common.ChunkedReader); ok {
+		for _, trailer := range chunkedBody.Trailers {
+			c.respVerbose(trailer)
+			exchange.Response.TrailerLines = append(exchange.Response.TrailerLines, strings.TrimSpace(trailer))
+		}
+	}
+	if !c.jsonOutput() {
+		bodyInfo.log("response")
+	}
+	common.SafeClose(resp.conn)
+}
+
+// logHop shows the request and status lines of every hop when redirects are followed, the verbose output shows them anyway.
+func (c *RawClient) logHop(req *rawRequest, line string) {
+	if c.options.Redirect.Follow && !c.verbose {
+		log.Printf("%s\n", line)
+	}
+}
+
+// hostPort returns the URL host with the port, the default port of the scheme is used when missing.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
 	}
-	fmt.Println(string(body))
-	return nil
+	return net.JoinHostPort(u.Hostname(), "80")
 }
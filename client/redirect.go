@@ -0,0 +1,142 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"rawh/common"
+	"strings"
+)
+
+type RedirectOptions struct {
+	Follow       bool
+	MaxRedirects int
+}
+
+func (o *RedirectOptions) Validate() error {
+	if o.Follow && o.MaxRedirects < 0 {
+		return fmt.Errorf("invalid redirect limit: %d", o.MaxRedirects)
+	}
+	return nil
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// redirectMethod returns the method of the redirected request and whether its body is kept, as browsers and curl do:
+// 301 and 302 change POST to GET, 303 changes everything but HEAD to GET, 307 and 308 keep the method and body.
+func redirectMethod(statusCode int, method string) (string, bool) {
+	switch {
+	case (statusCode == 301 || statusCode == 302) && method == "POST":
+		return "GET", false
+	case statusCode == 303 && method != "HEAD":
+		return "GET", false
+	case statusCode == 303:
+		return method, false
+	}
+	return method, true
+}
+
+// sensitiveHeaders are not sent to a different host, body headers are not sent when the body is dropped.
+var sensitiveHeaders = []string{"authorization", "cookie", "host"}
+var bodyHeaders = []string{"content-length", "content-type", "content-encoding", "transfer-encoding", "trailer"}
+
+// describeLocation explains how the Location value was resolved against the request URL.
+func describeLocation(from *url.URL, location *url.URL, resolved *url.URL) []string {
+	var notes []string
+	if !location.IsAbs() {
+		notes = append(notes, fmt.Sprintf("relative, resolved to %s", resolved))
+	}
+	if from.Scheme != resolved.Scheme {
+		notes = append(notes, fmt.Sprintf("crosses scheme %s -> %s", from.Scheme, resolved.Scheme))
+	}
+	if from.Host != resolved.Host {
+		notes = append(notes, fmt.Sprintf("crosses host %s -> %s", from.Host, resolved.Host))
+	}
+	return notes
+}
+
+func logRedirect(hop int, statusCode int, locationValue string, notes []string) {
+	log.Printf("# redirect %d: %d Location: %s\n", hop, statusCode, locationValue)
+	for _, note := range notes {
+		log.Printf("# redirect %d: %s\n", hop, note)
+	}
+}
+
+// nextRedirectRequest creates the request following the redirect response.
+func nextRedirectRequest(req *rawRequest, resp *rawResponse, hop int) (*rawRequest, error) {
+	locations := resp.headers.Values("Location")
+	if len(locations) == 0 {
+		log.Printf("# redirect %d: %d without Location, not following\n", hop, resp.statusCode)
+		return nil, nil
+	}
+	location, err := url.Parse(locations[0])
+	if err != nil {
+		return nil, fmt.Errorf("error parsing redirect location '%s': %v", locations[0], err)
+	}
+	next := &rawRequest{url: req.url.ResolveReference(location), body: req.body, chunked: req.chunked}
+	notes := describeLocation(req.url, location, next.url)
+	var keepBody bool
+	next.method, keepBody = redirectMethod(resp.statusCode, req.method)
+	if keepBody && !req.body.SizeKnown() {
+		log.Printf("# redirect %d: %d would resend the %s body, which cannot be read again, not following\n", hop, resp.statusCode, req.body.Description)
+		return nil, nil
+	}
+	if next.method != req.method {
+		notes = append(notes, fmt.Sprintf("method %s -> %s", req.method, next.method))
+	}
+	if !keepBody && !req.body.IsEmpty() {
+		notes = append(notes, "body dropped")
+	}
+	if !keepBody {
+		next.body = common.NewEmptyBody()
+		next.chunked = false
+	}
+	crossHost := req.url.Host != next.url.Host
+	for _, headerLine := range req.headers {
+		name, _, _ := common.SplitHeaderLine(headerLine)
+		name = strings.ToLower(strings.TrimSpace(name))
+		if (crossHost && containsString(sensitiveHeaders, name)) || (!keepBody && containsString(bodyHeaders, name)) {
+			notes = append(notes, fmt.Sprintf("header dropped: %s", headerLine))
+			continue
+		}
+		next.headers = append(next.headers, headerLine)
+	}
+	logRedirect(hop, resp.statusCode, locations[0], notes)
+	return next, nil
+}
+
+// checkCanonicalRedirect is the canonical client redirect policy, it shows every hop the http.Client follows.
+func (o *RedirectOptions) checkCanonicalRedirect(req *http.Request, via []*http.Request) error {
+	if !o.Follow {
+		return http.ErrUseLastResponse
+	}
+	if len(via) > o.MaxRedirects {
+		log.Printf("# redirect limit %d reached, not following\n", o.MaxRedirects)
+		return http.ErrUseLastResponse
+	}
+	prev, resp := via[len(via)-1], req.Response
+	proto := prev.Proto
+	if proto == "" {
+		proto = resp.Proto // requests created by redirects have no protocol set
+	}
+	log.Printf("> %s %s %s\n", prev.Method, prev.URL.RequestURI(), proto)
+	log.Printf("< %s %s\n", resp.Proto, resp.Status)
+	locationValue := resp.Header.Get("Location")
+	location, err := url.Parse(locationValue)
+	if err != nil {
+		location = req.URL
+	}
+	notes := describeLocation(prev.URL, location, req.URL)
+	if req.Method != prev.Method {
+		notes = append(notes, fmt.Sprintf("method %s -> %s", prev.Method, req.Method))
+	}
+	logRedirect(len(via), resp.StatusCode, locationValue, notes)
+	return nil
+}
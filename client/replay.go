@@ -0,0 +1,162 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"rawh/common"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReplayOptions select and pace the captured requests sent again by the replay.
+type ReplayOptions struct {
+	// Methods selects the requests by the method, all are selected when empty.
+	Methods []string
+	// Match selects the requests which head matches the regular expression.
+	Match string
+	// Limit stops the replay after the number of requests, 0 disables it.
+	Limit int
+	// Rate is the number of requests sent per second, 0 sends them one after another.
+	Rate float64
+}
+
+func (o *ReplayOptions) Validate() error {
+	if _, err := regexp.Compile(o.Match); err != nil {
+		return fmt.Errorf("invalid replay match expression: %v", err)
+	}
+	if o.Limit < 0 {
+		return fmt.Errorf("invalid replay limit: %d", o.Limit)
+	}
+	if o.Rate < 0 {
+		return fmt.Errorf("invalid replay rate: %v", o.Rate)
+	}
+	return nil
+}
+
+func (o *ReplayOptions) selects(capture *common.Capture, match *regexp.Regexp) bool {
+	if len(o.Methods) > 0 && !containsString(o.Methods, capture.Method()) {
+		return false
+	}
+	return match.Match(capture.Head())
+}
+
+// Replay sends the requests captured by the server to the target exactly as they were received,
+// the target only decides where to connect, the captured Host header is sent as it is.
+func (c *RawClient) Replay(captureFile string, target string, opts ReplayOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("error parsing replay target: %v", err)
+	}
+	captures, err := common.ReadCaptures(captureFile)
+	if err != nil {
+		return err
+	}
+	match := regexp.MustCompile(opts.Match)
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / opts.Rate)
+	}
+	var lastSent time.Time
+	sent, failed := 0, 0
+	for i := range captures {
+		capture := &captures[i]
+		if !opts.selects(capture, match) {
+			continue
+		}
+		if opts.Limit > 0 && sent >= opts.Limit {
+			break
+		}
+		if capture.BodyNotRead {
+			log.Printf("# capture %d: Transfer-Encoding body was not read by the server, skipped\n", i+1)
+			continue
+		}
+		if !capture.BodyCaptured() {
+			log.Printf("# capture %d: body of %d bytes was not captured, skipped\n", i+1, capture.BodySize)
+			continue
+		}
+		if wait := interval - time.Since(lastSent); !lastSent.IsZero() && wait > 0 {
+			time.Sleep(wait)
+		}
+		lastSent = time.Now()
+		log.Printf("# capture %d: connection %d from %s at %s\n", i+1, capture.ConnectionId, capture.RemoteAddress, capture.Time.Format(time.RFC3339Nano))
+		if err := c.SendRawMessage(targetURL, capture.Head(), capture.Body); err != nil {
+			log.Printf("# capture %d: %v\n", i+1, err)
+			failed++
+		}
+		sent++
+	}
+	log.Printf("# replayed %d of %d captured requests\n", sent, len(captures))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replayed requests failed", failed, sent)
+	}
+	return nil
+}
+
+// SendRawMessage sends the request head and body byte by byte as they are and reads the response,
+// no header is added or changed, only the pace of sending follows the options.
+func (c *RawClient) SendRawMessage(target *url.URL, head []byte, body []byte) error {
+	lines := strings.SplitAfter(string(head), "\n")
+	startLine := strings.TrimSpace(lines[0])
+	method, _, _ := strings.Cut(startLine, " ")
+	exchange := newExchange(method, target.String())
+	exchange.Request.StartLine = startLine
+	for _, line := range lines[1:] {
+		if line = strings.TrimSpace(line); line != "" {
+			exchange.Request.HeaderLines = append(exchange.Request.HeaderLines, line)
+		}
+	}
+	conn, err := c.dial(target, exchange)
+	if err != nil {
+		c.emit(exchange, err)
+		return err
+	}
+	writer := newRequestWriter(conn, c.options.Slow)
+	_, err = writer.Write(head)
+	if c.verbose {
+		for _, line := range lines {
+			if line != "" {
+				c.lineVerbose(">", line)
+			}
+		}
+	} else {
+		log.Printf("> %s\n", startLine)
+	}
+	writer.startBody()
+	if err == nil {
+		_, err = writer.Write(body)
+	}
+	bodyDigest := common.NewBodyDigest()
+	_, _ = bodyDigest.Write(body)
+	exchange.Request.Body = newBodyInfo(bodyDigest)
+	if !c.jsonOutput() && len(body) > 0 {
+		exchange.Request.Body.log("request")
+	}
+	exchange.Timings.RequestMs = exchange.since()
+	if err != nil && !errors.Is(err, errRequestCut) {
+		log.Printf("error sending request: %v, reading the response anyway", err)
+	}
+	if writer.aborted {
+		exchange.Error = "connection aborted while sending the request"
+		c.emit(exchange, nil)
+		return nil
+	}
+	resp, err := c.readResponse(conn, bufio.NewReader(conn), exchange, method)
+	if err != nil {
+		c.emit(exchange, err)
+		return err
+	}
+	if !c.verbose {
+		log.Printf("< %s\n", resp.statusLine)
+	}
+	bodyInfo, err := processResponseBody(resp.body, resp.contentEncoding(), c.options, nil)
+	c.finishResponse(exchange, resp, bodyInfo)
+	c.emit(exchange, err)
+	return err
+}
@@ -0,0 +1,286 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DecompressOff  = "off"
+	DecompressAuto = "auto"
+)
+
+type ResponseOptions struct {
+	// OutputFile receives the response body instead of the standard output.
+	OutputFile string
+	// Decompress is 'off', 'auto' (according to the Content-Encoding header) or a content coding forced regardless of the header.
+	Decompress string
+}
+
+func (o *ResponseOptions) Validate() error {
+	switch o.Decompress {
+	case "", DecompressOff, DecompressAuto:
+		return nil
+	}
+	for _, encoding := range common.ParseContentEncodings(o.Decompress) {
+		if !containsString(common.SupportedContentEncodings, encoding) {
+			return fmt.Errorf("unsupported decompression: %s", o.Decompress)
+		}
+	}
+	return nil
+}
+
+// decodings returns the content codings to be decoded in the order of decoding.
+func (o *ResponseOptions) decodings(contentEncoding string) []string {
+	switch o.Decompress {
+	case "", DecompressOff:
+		return nil
+	case DecompressAuto:
+		return common.ParseContentEncodings(contentEncoding)
+	default:
+		return common.ParseContentEncodings(o.Decompress)
+	}
+}
+
+// rawResponse is the response head read without any normalization.
+type rawResponse struct {
+	statusLine string
+	statusCode int
+	headers    *common.HttpHeaders
+	body       io.Reader
+	conn       net.Conn
+	// reader is the buffered connection reader, it continues the upgraded connection after the 101 response
+	reader *bufio.Reader
+}
+
+// ExchangeInterim is a 1xx response received before the final one.
+type ExchangeInterim struct {
+	StatusLine  string   `json:"statusLine"`
+	StatusCode  int      `json:"statusCode"`
+	HeaderLines []string `json:"headerLines"`
+	ReceivedMs  float64  `json:"receivedMs"`
+}
+
+// interim reports whether the response is an informational one followed by another response, 101 is final.
+func (r *rawResponse) interim() bool {
+	return r.statusCode >= 100 && r.statusCode < 200 && r.statusCode != 101
+}
+
+// readFinalResponseHead reads the response heads until the final one, the interim ones are recorded in the exchange.
+func (c *RawClient) readFinalResponseHead(reader *bufio.Reader, exchange *Exchange) (*rawResponse, error) {
+	for {
+		resp, err := c.readResponseHead(reader)
+		if err != nil || !resp.interim() {
+			return resp, err
+		}
+		c.recordInterim(exchange, resp)
+	}
+}
+
+// recordInterim adds the interim response to the exchange and shows it with its timing.
+func (c *RawClient) recordInterim(exchange *Exchange, resp *rawResponse) {
+	interim := ExchangeInterim{StatusLine: resp.statusLine, StatusCode: resp.statusCode, HeaderLines: []string{}, ReceivedMs: exchange.since()}
+	for _, field := range resp.headers.Fields {
+		interim.HeaderLines = append(interim.HeaderLines, field.Name+": "+field.Value)
+	}
+	exchange.Interim = append(exchange.Interim, interim)
+	log.Printf("# interim response after %.1fms: %s\n", interim.ReceivedMs, interim.StatusLine)
+	if !c.verbose {
+		for _, line := range interim.HeaderLines {
+			log.Printf("< %s\n", line)
+		}
+	}
+}
+
+func (c *RawClient) readResponseHead(reader *bufio.Reader) (*rawResponse, error) {
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("error reading response status: %v", err)
+	}
+	c.respVerbose(statusLine)
+	resp := &rawResponse{statusLine: strings.TrimSpace(statusLine), headers: common.NewHttpHeaders(false)}
+	if parts := strings.SplitN(resp.statusLine, " ", 3); len(parts) >= 2 {
+		resp.statusCode, _ = strconv.Atoi(parts[1])
+	}
+	// Reading headers directly without normalization
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("error reading headers: %v", err)
+		}
+		c.respVerbose(line)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break // header section end
+		}
+		if err := resp.headers.AddLine(line); err != nil {
+			log.Printf("read header line '%s' error: %v", line, err)
+		}
+	}
+	return resp, nil
+}
+
+// bodyReader frames the response body according to RFC 9112 section 6.3.
+func (r *rawResponse) bodyReader(reader *bufio.Reader, method string) io.Reader {
+	if method == "HEAD" || r.statusCode/100 == 1 || r.statusCode == 204 || r.statusCode == 304 {
+		return strings.NewReader("")
+	}
+	for _, value := range r.headers.Values(common.TransferEncodingHeaderName) {
+		if strings.Contains(strings.ToLower(value), "chunked") {
+			return common.NewChunkedReader(reader)
+		}
+	}
+	if r.headers.Has(common.ContentLengthHeaderName) {
+		return io.LimitReader(reader, int64(r.headers.ContentLength))
+	}
+	return reader // the body ends when the connection is closed
+}
+
+func (r *rawResponse) contentEncoding() string {
+	return strings.Join(r.headers.Values(common.ContentEncodingHeaderName), ", ")
+}
+
+// maxCapturedContent limits the body content included in the exchange document.
+const maxCapturedContent = 1024 * 1024
+
+// processResponseBody writes the body to the output, decoding it when requested, and describes its raw and decoded size and hash.
+// The body is captured for the JSON exchange document, which replaces the standard output, and for the HAR log.
+// The event stream monitor, when set, gets the decoded content as it arrives and may stop the reading.
+func processResponseBody(body io.Reader, contentEncoding string, options Options, monitor *sseMonitor) (BodyInfo, error) {
+	var info BodyInfo
+	var captured bytes.Buffer
+	opts := options.Response
+	out := io.Writer(os.Stdout)
+	if opts.OutputFile != "" {
+		file, err := os.Create(opts.OutputFile)
+		if err != nil {
+			return info, fmt.Errorf("error creating output file: %v", err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				log.Printf("Error closing output file: %v", err)
+			}
+		}()
+		out = file
+		info.OutputFile = opts.OutputFile
+	} else if options.OutputFormat == OutputFormatJson {
+		out = io.Discard
+	}
+	if (options.OutputFormat == OutputFormatJson && opts.OutputFile == "") || options.Har != nil {
+		out = io.MultiWriter(out, &limitedWriter{buf: &captured, limit: maxCapturedContent})
+	}
+	source := &sourceReader{reader: body}
+	rawDigest := common.NewBodyDigest()
+	rawBody := bufio.NewReader(io.TeeReader(source, rawDigest))
+	var content io.Reader = rawBody
+	var decodedDigest *common.BodyDigest
+	encodings := opts.decodings(contentEncoding)
+	if _, err := rawBody.Peek(1); err != nil {
+		encodings = nil // the empty body, e.g. of the HEAD, 204 and 304 responses, has nothing to decode
+	}
+	if len(encodings) > 0 {
+		head := &recordingReader{reader: rawBody, recording: true}
+		decoded, err := common.NewDecodingReader(head, encodings)
+		head.recording = false
+		if err != nil {
+			// the body is written as received, including the bytes read by the failed decoder
+			info.DecodingError = err.Error()
+			content = io.MultiReader(bytes.NewReader(head.recorded.Bytes()), rawBody)
+		} else {
+			decodedDigest = common.NewBodyDigest()
+			content = io.TeeReader(decoded, decodedDigest)
+		}
+	}
+	if monitor != nil {
+		content = io.TeeReader(content, monitor)
+	}
+	_, copyErr := io.Copy(out, content)
+	if errors.Is(copyErr, errSseComplete) {
+		copyErr = nil // the rest of the stream is not read
+	} else if copyErr != nil && decodedDigest != nil && source.err == nil {
+		// the decoder failed in the middle of the body, the rest of it is read to report its size and hash
+		info.DecodingError = fmt.Sprintf("error decoding '%s' content: %v", strings.Join(encodings, ", "), copyErr)
+		_, copyErr = io.Copy(io.Discard, rawBody)
+	} else if copyErr == nil {
+		_, copyErr = io.Copy(io.Discard, rawBody) // data after the end of the encoded stream
+	}
+	info.Size, info.Hash = rawDigest.Size(), rawDigest.Hash()
+	if decodedDigest != nil {
+		info.Decoding = strings.Join(encodings, ", ")
+		info.DecodedSize, info.DecodedHash = decodedDigest.Size(), decodedDigest.Hash()
+	}
+	if captured.Len() > 0 {
+		if utf8.Valid(captured.Bytes()) {
+			info.Content = captured.String()
+		} else {
+			info.ContentBase64 = base64.StdEncoding.EncodeToString(captured.Bytes())
+		}
+	}
+	if copyErr != nil {
+		return info, fmt.Errorf("error reading response: %v", copyErr)
+	}
+	return info, nil
+}
+
+// sourceReader keeps the error of the response body, which tells it apart from the decoding error.
+type sourceReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// recordingReader keeps the bytes read while recording, they are written as received when the decoder cannot start.
+type recordingReader struct {
+	reader    io.Reader
+	recording bool
+	recorded  bytes.Buffer
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.recording {
+		r.recorded.Write(p[:n])
+	}
+	return n, err
+}
+
+// discardResponseBody reads the body of a response which is not the final one, e.g. a redirect.
+func discardResponseBody(body io.Reader) (BodyInfo, error) {
+	digest := common.NewBodyDigest()
+	_, err := io.Copy(digest, body)
+	if err != nil {
+		err = fmt.Errorf("error reading response: %v", err)
+	}
+	return newBodyInfo(digest), err
+}
+
+// limitedWriter keeps only the beginning of the written data.
+type limitedWriter struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); room > 0 {
+		w.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// SlowOptions control the pace of the request sending, offsets are counted in bytes.
+type SlowOptions struct {
+	DribbleBytes          int
+	DribbleInterval       time.Duration
+	PauseAfterRequestLine time.Duration
+	PauseAfterHeaders     time.Duration
+	PauseInBody           time.Duration
+	PauseInBodyOffset     int64
+	// AbortAt closes the connection when the request offset is reached, a negative value disables it.
+	AbortAt int64
+	// HalfCloseAt closes the writing side of the connection when the request offset is reached, a negative value disables it.
+	HalfCloseAt int64
+}
+
+func (o *SlowOptions) Validate() error {
+	if o.DribbleBytes < 0 {
+		return fmt.Errorf("invalid dribble bytes: %d", o.DribbleBytes)
+	}
+	if o.DribbleBytes > 0 && o.DribbleInterval <= 0 {
+		return fmt.Errorf("dribble interval must be positive")
+	}
+	if o.PauseInBodyOffset < 0 {
+		return fmt.Errorf("invalid pause in body offset: %d", o.PauseInBodyOffset)
+	}
+	return nil
+}
+
+func (o *SlowOptions) cutAt() int64 {
+	switch {
+	case o.AbortAt >= 0 && (o.HalfCloseAt < 0 || o.AbortAt <= o.HalfCloseAt):
+		return o.AbortAt
+	default:
+		return o.HalfCloseAt
+	}
+}
+
+var errRequestCut = errors.New("request sending stopped deliberately")
+
+// requestWriter writes the request to the connection at the pace defined by the options,
+// once the cut offset is reached all following writes fail with errRequestCut.
+type requestWriter struct {
+	conn      net.Conn
+	opts      SlowOptions
+	written   int64
+	bodyStart int64
+	paused    bool
+	cut       bool
+	aborted   bool
+}
+
+func newRequestWriter(conn net.Conn, opts SlowOptions) *requestWriter {
+	return &requestWriter{conn: conn, opts: opts, bodyStart: -1}
+}
+
+// startBody marks the current offset as the beginning of the body.
+func (w *requestWriter) startBody() {
+	w.bodyStart = w.written
+}
+
+func (w *requestWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.cut {
+			return total, errRequestCut
+		}
+		n := len(p)
+		if w.opts.DribbleBytes > 0 {
+			n = min(n, w.opts.DribbleBytes)
+			if w.written > 0 {
+				time.Sleep(w.opts.DribbleInterval)
+			}
+		}
+		if cutAt := w.opts.cutAt(); cutAt >= 0 {
+			n = int(min(int64(n), cutAt-w.written))
+		}
+		pauseAt := int64(-1)
+		if w.opts.PauseInBody > 0 && w.bodyStart >= 0 && !w.paused {
+			pauseAt = w.bodyStart + w.opts.PauseInBodyOffset
+			n = int(min(int64(n), pauseAt-w.written))
+		}
+		if n > 0 {
+			written, err := w.conn.Write(p[:n])
+			w.written += int64(written)
+			total += written
+			if err != nil {
+				return total, err
+			}
+			p = p[n:]
+		}
+		if w.written == pauseAt {
+			w.paused = true
+			log.Printf("# pausing for %s at body byte %d\n", w.opts.PauseInBody, w.opts.PauseInBodyOffset)
+			time.Sleep(w.opts.PauseInBody)
+		}
+		if w.written == w.opts.cutAt() {
+			w.cutConnection()
+		}
+	}
+	return total, nil
+}
+
+func (w *requestWriter) cutConnection() {
+	w.cut = true
+	if w.written == w.opts.AbortAt {
+		w.aborted = true
+		log.Printf("# aborting the connection after %d bytes of the request\n", w.written)
+		if err := w.conn.Close(); err != nil {
+			log.Printf("Error closing connection: %v", err)
+		}
+		return
+	}
+	log.Printf("# half-closing the connection after %d bytes of the request\n", w.written)
+	if closeWriter, ok := w.conn.(interface{ CloseWrite() error }); ok {
+		if err := closeWriter.CloseWrite(); err != nil {
+			log.Printf("Error half-closing connection: %v", err)
+		}
+	} else {
+		log.Printf("Error half-closing connection: not supported by %T", w.conn)
+	}
+}
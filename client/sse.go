@@ -0,0 +1,190 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	SseVerdictStreaming    = "streaming"
+	SseVerdictBuffered     = "buffered"
+	SseVerdictCoalescing   = "coalescing"
+	SseVerdictDelaying     = "delaying"
+	SseVerdictInconclusive = "inconclusive"
+)
+
+const sseContentType = "text/event-stream"
+
+// sseTolerance is the receive time difference still considered as the network jitter.
+const sseTolerance = 50 * time.Millisecond
+
+// errSseComplete stops reading the event stream after the requested number of events.
+var errSseComplete = errors.New("event stream complete")
+
+// SseOptions make the client request a Server-Sent Events stream and compare the send time of every event,
+// emitted by 'rawh server', with its receive time, to find out whether an intermediary buffers the stream.
+type SseOptions struct {
+	Enabled bool
+	// MaxEvents stops reading the stream after the number of events, 0 reads it until the server ends it.
+	MaxEvents int
+}
+
+func (o *SseOptions) Validate() error {
+	if o.MaxEvents < 0 {
+		return fmt.Errorf("invalid number of events: %d", o.MaxEvents)
+	}
+	return nil
+}
+
+// ExchangeSse is the delivery analysis of the received events.
+type ExchangeSse struct {
+	Events []ExchangeSseEvent `json:"events"`
+	// ClockOffsetMs is the smallest transit time, the network latency plus the difference of the server and the local clock.
+	ClockOffsetMs float64 `json:"clockOffsetMs"`
+	// MaxLagMs is the largest transit time above the clock offset.
+	MaxLagMs float64 `json:"maxLagMs"`
+	// CoalescedEvents arrived together with the previous event although they were sent separately.
+	CoalescedEvents int    `json:"coalescedEvents"`
+	Verdict         string `json:"verdict"`
+}
+
+// ExchangeSseEvent is a received event, the send time is known for the events emitted by 'rawh server'.
+type ExchangeSseEvent struct {
+	Id         string     `json:"id,omitempty"`
+	Event      string     `json:"event,omitempty"`
+	SentAt     *time.Time `json:"sentAt,omitempty"`
+	ReceivedMs float64    `json:"receivedMs"`
+	TransitMs  float64    `json:"transitMs,omitempty"`
+	receivedAt time.Time
+}
+
+// sseMonitor parses the event stream as it is read and records the receive time of every event,
+// the bytes delivered by the same read share it.
+type sseMonitor struct {
+	exchange  *Exchange
+	maxEvents int
+	quiet     bool
+	line      []byte
+	event     ExchangeSseEvent
+	data      []string
+}
+
+// newSseMonitor returns the event stream monitor of the exchange, nil when it is disabled.
+func newSseMonitor(exchange *Exchange, options Options) *sseMonitor {
+	if !options.Sse.Enabled {
+		return nil
+	}
+	exchange.Sse = &ExchangeSse{Events: []ExchangeSseEvent{}}
+	return &sseMonitor{exchange: exchange, maxEvents: options.Sse.MaxEvents, quiet: options.OutputFormat == OutputFormatJson}
+}
+
+func (m *sseMonitor) Write(p []byte) (int, error) {
+	receivedAt := time.Now()
+	for _, b := range p {
+		if b != '\n' {
+			m.line = append(m.line, b)
+			continue
+		}
+		m.processLine(strings.TrimSuffix(string(m.line), "\r"), receivedAt)
+		m.line = m.line[:0]
+		if m.maxEvents > 0 && len(m.exchange.Sse.Events) >= m.maxEvents {
+			return len(p), errSseComplete
+		}
+	}
+	return len(p), nil
+}
+
+// processLine handles the event stream line (https://html.spec.whatwg.org/multipage/server-sent-events.html), the empty one dispatches the event.
+func (m *sseMonitor) processLine(line string, receivedAt time.Time) {
+	if line == "" {
+		if len(m.data) > 0 || m.event.Id != "" || m.event.Event != "" {
+			m.dispatch(receivedAt)
+		}
+		return
+	}
+	if strings.HasPrefix(line, ":") {
+		return // comment
+	}
+	name, value, _ := strings.Cut(line, ":")
+	value = strings.TrimPrefix(value, " ")
+	switch name {
+	case "id":
+		m.event.Id = value
+	case "event":
+		m.event.Event = value
+	case "data":
+		m.data = append(m.data, value)
+	}
+}
+
+func (m *sseMonitor) dispatch(receivedAt time.Time) {
+	event := m.event
+	event.receivedAt = receivedAt
+	event.ReceivedMs = float64(receivedAt.Sub(m.exchange.start).Microseconds()) / 1000
+	var data struct {
+		SentAt time.Time `json:"sentAt"`
+	}
+	if err := json.Unmarshal([]byte(strings.Join(m.data, "\n")), &data); err == nil && !data.SentAt.IsZero() {
+		event.SentAt = &data.SentAt
+		event.TransitMs = float64(receivedAt.Sub(data.SentAt).Microseconds()) / 1000
+	}
+	m.exchange.Sse.Events = append(m.exchange.Sse.Events, event)
+	m.event, m.data = ExchangeSseEvent{}, nil
+	if !m.quiet {
+		if event.SentAt != nil {
+			log.Printf("# sse-event: id %s received after %.1fms, transit %.1fms\n", event.Id, event.ReceivedMs, event.TransitMs)
+		} else {
+			log.Printf("# sse-event: id %s received after %.1fms, no send time\n", event.Id, event.ReceivedMs)
+		}
+	}
+}
+
+// finish analyzes the delivery of the received events: the events arriving together although they were sent apart
+// were coalesced, all of them arriving together means the stream was buffered, and the transit times growing
+// above the clock offset mean the events were delayed.
+func (m *sseMonitor) finish() {
+	if m == nil {
+		return
+	}
+	sse := m.exchange.Sse
+	var timed []ExchangeSseEvent
+	for _, event := range sse.Events {
+		if event.SentAt != nil {
+			timed = append(timed, event)
+		}
+	}
+	sse.Verdict = SseVerdictInconclusive
+	if len(timed) >= 2 {
+		sse.ClockOffsetMs = timed[0].TransitMs
+		for _, event := range timed {
+			sse.ClockOffsetMs = min(sse.ClockOffsetMs, event.TransitMs)
+		}
+		for i, event := range timed {
+			sse.MaxLagMs = max(sse.MaxLagMs, event.TransitMs-sse.ClockOffsetMs)
+			if i > 0 && event.receivedAt.Sub(timed[i-1].receivedAt) < sseTolerance && event.SentAt.Sub(*timed[i-1].SentAt) >= sseTolerance {
+				sse.CoalescedEvents++
+			}
+		}
+		switch {
+		case sse.CoalescedEvents == len(timed)-1:
+			sse.Verdict = SseVerdictBuffered
+		case sse.CoalescedEvents > 0:
+			sse.Verdict = SseVerdictCoalescing
+		case sse.MaxLagMs > float64(sseTolerance.Milliseconds()):
+			sse.Verdict = SseVerdictDelaying
+		default:
+			sse.Verdict = SseVerdictStreaming
+		}
+	}
+	if !m.quiet {
+		log.Printf("# sse-events: %d (%d with the send time)\n", len(sse.Events), len(timed))
+		log.Printf("# sse-clock-offset: %.1fms\n", sse.ClockOffsetMs)
+		log.Printf("# sse-max-lag: %.1fms\n", sse.MaxLagMs)
+		log.Printf("# sse-coalesced-events: %d\n", sse.CoalescedEvents)
+		log.Printf("# sse-verdict: %s\n", sse.Verdict)
+	}
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxWebSocketPayload limits the payload of the received frames.
+const maxWebSocketPayload = 16 * 1024 * 1024
+
+// WebSocketOptions make the raw client perform the WebSocket opening handshake, the missing handshake headers are added
+// after the user ones, and then send the frames given in the input syntax: a line is sent as a text frame,
+// '/text <text>', '/binary <hex>', '/ping [payload]', '/pong [payload]' and '/close [code [reason]]' send the other frames
+// and '/wait <duration>' pauses the sending.
+type WebSocketOptions struct {
+	Enabled bool
+	// Send are the input lines sent after the handshake, the standard input is read when there are none.
+	Send []string
+	// Wait is how long the client waits for the frames after the input ends and for the close reply.
+	Wait time.Duration
+}
+
+func (o *WebSocketOptions) Validate() error {
+	if o.Wait < 0 {
+		return fmt.Errorf("invalid WebSocket wait: %s", o.Wait)
+	}
+	for _, line := range o.Send {
+		if _, _, err := parseWebSocketInput(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webSocketHandshakeLines returns the handshake headers which were not set by the user.
+func (o *WebSocketOptions) webSocketHandshakeLines(reqHeaders *common.HttpHeaders) []string {
+	var lines []string
+	for _, field := range []common.HeaderField{
+		{Name: "Upgrade", Value: "websocket"},
+		{Name: "Connection", Value: "Upgrade"},
+		{Name: "Sec-WebSocket-Key", Value: common.NewWebSocketKey()},
+		{Name: "Sec-WebSocket-Version", Value: "13"},
+	} {
+		if !reqHeaders.Has(field.Name) {
+			lines = append(lines, field.Name+": "+field.Value)
+		}
+	}
+	return lines
+}
+
+// parseWebSocketInput returns the frame of the input line, or the pause of the '/wait' command.
+func parseWebSocketInput(line string) (*common.WebSocketFrame, time.Duration, error) {
+	if !strings.HasPrefix(line, "/") {
+		return &common.WebSocketFrame{Fin: true, Opcode: common.WebSocketOpText, Payload: []byte(line)}, 0, nil
+	}
+	command, argument, _ := strings.Cut(line, " ")
+	frame := &common.WebSocketFrame{Fin: true}
+	switch command {
+	case "/text":
+		frame.Opcode, frame.Payload = common.WebSocketOpText, []byte(argument)
+	case "/binary":
+		payload, err := hex.DecodeString(strings.ReplaceAll(argument, " ", ""))
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid WebSocket binary payload '%s': %v", argument, err)
+		}
+		frame.Opcode, frame.Payload = common.WebSocketOpBinary, payload
+	case "/ping":
+		frame.Opcode, frame.Payload = common.WebSocketOpPing, []byte(argument)
+	case "/pong":
+		frame.Opcode, frame.Payload = common.WebSocketOpPong, []byte(argument)
+	case "/close":
+		frame.Opcode = common.WebSocketOpClose
+		if argument != "" {
+			codeSpec, reason, _ := strings.Cut(argument, " ")
+			code, err := strconv.Atoi(codeSpec)
+			if err != nil || code < 1000 || code > 4999 {
+				return nil, 0, fmt.Errorf("invalid WebSocket close code '%s'", codeSpec)
+			}
+			frame.Payload = common.NewWebSocketClosePayload(code, reason)
+		}
+	case "/wait":
+		pause, err := time.ParseDuration(argument)
+		if err != nil || pause < 0 {
+			return nil, 0, fmt.Errorf("invalid WebSocket wait '%s'", argument)
+		}
+		return nil, pause, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown WebSocket input command '%s' (commands: /text, /binary, /ping, /pong, /close, /wait)", command)
+	}
+	return frame, 0, nil
+}
+
+// ExchangeWebSocket describes the WebSocket session following the handshake.
+type ExchangeWebSocket struct {
+	Accept      string                   `json:"accept"`
+	AcceptValid bool                     `json:"acceptValid"`
+	Frames      []ExchangeWebSocketFrame `json:"frames"`
+}
+
+type ExchangeWebSocketFrame struct {
+	Direction   string  `json:"direction"`
+	TimeMs      float64 `json:"timeMs"`
+	Description string  `json:"description"`
+	// Payload is the content of the text frames.
+	Payload string `json:"payload,omitempty"`
+}
+
+// webSocketSession records the frames of both directions, the received ones are read concurrently with the sending.
+type webSocketSession struct {
+	client    *RawClient
+	conn      net.Conn
+	exchange  *Exchange
+	mutex     sync.Mutex
+	closeSent bool
+	// closed is closed when the close frame was received or the connection ended
+	closed chan struct{}
+}
+
+// runWebSocket checks the Sec-WebSocket-Accept of the handshake response and exchanges the frames until the close handshake.
+func (c *RawClient) runWebSocket(exchange *Exchange, resp *rawResponse) error {
+	key := ""
+	for _, line := range exchange.Request.HeaderLines {
+		if name, value, err := common.SplitHeaderLine(line); err == nil && strings.EqualFold(name, "Sec-WebSocket-Key") {
+			key = strings.TrimSpace(value)
+		}
+	}
+	ws := &ExchangeWebSocket{Frames: []ExchangeWebSocketFrame{}}
+	if values := resp.headers.Values("Sec-WebSocket-Accept"); len(values) > 0 {
+		ws.Accept = strings.TrimSpace(values[0])
+	}
+	ws.AcceptValid = ws.Accept == common.WebSocketAccept(key)
+	exchange.WebSocket = ws
+	if !c.jsonOutput() {
+		if ws.AcceptValid {
+			log.Printf("# ws-accept: valid\n")
+		} else {
+			log.Printf("# ws-accept: invalid '%s', expected '%s'\n", ws.Accept, common.WebSocketAccept(key))
+		}
+	}
+	session := &webSocketSession{client: c, conn: resp.conn, exchange: exchange, closed: make(chan struct{})}
+	go session.receive(resp.reader)
+	return session.send(c.webSocketInput())
+}
+
+// webSocketInput returns the input lines, read from the standard input when no lines are given in the options.
+func (c *RawClient) webSocketInput() <-chan string {
+	input := make(chan string)
+	go func() {
+		defer close(input)
+		if len(c.options.WebSocket.Send) > 0 {
+			for _, line := range c.options.WebSocket.Send {
+				input <- line
+			}
+			return
+		}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			input <- scanner.Text()
+		}
+	}()
+	return input
+}
+
+// send sends the input frames, after the input ends it waits for the frames and closes the session with 1000.
+func (s *webSocketSession) send(input <-chan string) error {
+	wait := s.client.options.WebSocket.Wait
+	for {
+		select {
+		case <-s.closed:
+			return nil
+		case line, ok := <-input:
+			if !ok {
+				select {
+				case <-s.closed:
+					return nil
+				case <-time.After(wait):
+				}
+				if err := s.write(&common.WebSocketFrame{Fin: true, Opcode: common.WebSocketOpClose, Payload: common.NewWebSocketClosePayload(1000, "")}); err != nil {
+					return err
+				}
+				s.awaitClose(wait)
+				return nil
+			}
+			frame, pause, err := parseWebSocketInput(line)
+			if err != nil {
+				log.Printf("%v\n", err)
+				continue
+			}
+			if frame == nil {
+				time.Sleep(pause)
+				continue
+			}
+			if err := s.write(frame); err != nil {
+				return err
+			}
+			if frame.Opcode == common.WebSocketOpClose {
+				s.awaitClose(wait)
+				return nil
+			}
+		}
+	}
+}
+
+func (s *webSocketSession) awaitClose(wait time.Duration) {
+	select {
+	case <-s.closed:
+	case <-time.After(wait):
+		log.Printf("# ws-close: no reply within %s\n", wait)
+	}
+}
+
+// write sends the masked client frame, the close frame is sent once.
+func (s *webSocketSession) write(frame *common.WebSocketFrame) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if frame.Opcode == common.WebSocketOpClose {
+		if s.closeSent {
+			return nil
+		}
+		s.closeSent = true
+	}
+	frame.Masked = true
+	if err := common.WriteWebSocketFrame(s.conn, frame); err != nil {
+		return fmt.Errorf("error sending WebSocket frame: %v", err)
+	}
+	s.record(">", frame)
+	return nil
+}
+
+// receive reads the frames until the close frame, the pings are answered, the close frame is replied when it was not sent.
+func (s *webSocketSession) receive(reader *bufio.Reader) {
+	defer close(s.closed)
+	for {
+		frame, err := common.ReadWebSocketFrame(reader, maxWebSocketPayload)
+		if err != nil {
+			if frame != nil {
+				s.mutex.Lock()
+				s.record("<", frame)
+				s.mutex.Unlock()
+			}
+			if !s.closeSentLocked() {
+				log.Printf("# ws-connection: %v\n", err)
+			}
+			return
+		}
+		s.mutex.Lock()
+		s.record("<", frame)
+		s.mutex.Unlock()
+		switch frame.Opcode {
+		case common.WebSocketOpPing:
+			if err := s.write(&common.WebSocketFrame{Fin: true, Opcode: common.WebSocketOpPong, Payload: frame.Payload}); err != nil {
+				log.Printf("%v\n", err)
+			}
+		case common.WebSocketOpClose:
+			code, reason := common.ParseWebSocketClosePayload(frame.Payload)
+			if err := s.write(&common.WebSocketFrame{Fin: true, Opcode: common.WebSocketOpClose, Payload: common.NewWebSocketClosePayload(code, reason)}); err != nil {
+				log.Printf("%v\n", err)
+			}
+			return
+		}
+	}
+}
+
+func (s *webSocketSession) closeSentLocked() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeSent
+}
+
+// record adds the frame to the exchange and shows it, the text payload goes to the standard output; the mutex is held.
+func (s *webSocketSession) record(direction string, frame *common.WebSocketFrame) {
+	recorded := ExchangeWebSocketFrame{Direction: direction, TimeMs: s.exchange.since(), Description: frame.Describe()}
+	if frame.Opcode == common.WebSocketOpText || (frame.Opcode == common.WebSocketOpContinuation && frame.Payload != nil) {
+		recorded.Payload = string(frame.Payload)
+	}
+	s.exchange.WebSocket.Frames = append(s.exchange.WebSocket.Frames, recorded)
+	if s.client.jsonOutput() {
+		return
+	}
+	log.Printf("%s ws-frame after %.1fms: %s\n", direction, recorded.TimeMs, recorded.Description)
+	if direction == "<" && recorded.Payload != "" {
+		fmt.Println(recorded.Payload)
+	}
+}
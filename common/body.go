@@ -0,0 +1,191 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"hash"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"strings"
+)
+
+const (
+	GeneratorPattern        = "pattern"
+	GeneratorZeros          = "zeros"
+	GeneratorRandom         = "random"
+	GeneratorIncompressible = "incompressible"
+)
+
+const DefaultGeneratorPattern = "1234567890"
+
+// Body is a request body source, its content is streamed to the connection instead of being built in memory.
+// Every call to Open starts the content from the beginning, so the body can be sent more than once, except the standard input.
+// The Size is -1 when it is not known before the body is sent.
+type Body struct {
+	Size        int64
+	Description string
+	open        func() (io.ReadCloser, error)
+}
+
+func (b *Body) IsEmpty() bool {
+	return b == nil || b.Size == 0
+}
+
+// SizeKnown reports whether the body can be framed by Content-Length, the other bodies are sent chunked.
+func (b *Body) SizeKnown() bool {
+	return b == nil || b.Size >= 0
+}
+
+func (b *Body) Open() (io.ReadCloser, error) {
+	if b.IsEmpty() {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	return b.open()
+}
+
+func NewEmptyBody() *Body {
+	return NewStringBody("")
+}
+
+func NewStringBody(data string) *Body {
+	return &Body{
+		Size:        int64(len(data)),
+		Description: "inline data",
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(data)), nil
+		},
+	}
+}
+
+func NewFileBody(path string) (*Body, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading body file: %v", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("error reading body file: %s is a directory", path)
+	}
+	return &Body{
+		Size:        info.Size(),
+		Description: "file " + path,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}, nil
+}
+
+// NewStdinBody streams the standard input, its size is unknown until it ends and it can be sent only once.
+func NewStdinBody() *Body {
+	opened := false
+	return &Body{
+		Size:        -1,
+		Description: "stdin",
+		open: func() (io.ReadCloser, error) {
+			if opened {
+				return nil, fmt.Errorf("the standard input was already sent")
+			}
+			opened = true
+			return io.NopCloser(os.Stdin), nil
+		},
+	}
+}
+
+// ParseDataArg interprets the client data argument: '@-' reads stdin, '@file' reads the file, anything else is inline data.
+func ParseDataArg(data string) (*Body, error) {
+	switch {
+	case data == "@-":
+		return NewStdinBody(), nil
+	case strings.HasPrefix(data, "@"):
+		return NewFileBody(data[1:])
+	default:
+		return NewStringBody(data), nil
+	}
+}
+
+// NewGeneratedBody returns a body which content is produced on the fly by the generator.
+func NewGeneratedBody(generator string, size int64, seed int64, pattern string) (*Body, error) {
+	var newReader func() io.Reader
+	switch generator {
+	case GeneratorPattern:
+		if pattern == "" {
+			pattern = DefaultGeneratorPattern
+		}
+		newReader = func() io.Reader { return &patternReader{pattern: []byte(pattern)} }
+	case GeneratorZeros:
+		newReader = func() io.Reader { return zeroReader{} }
+	case GeneratorRandom:
+		newReader = func() io.Reader { return &randomTextReader{rnd: mathrand.New(mathrand.NewSource(seed))} }
+	case GeneratorIncompressible:
+		newReader = func() io.Reader { return rand.Reader }
+	default:
+		return nil, fmt.Errorf("unsupported data generator: %s", generator)
+	}
+	return &Body{
+		Size:        size,
+		Description: "generated " + generator,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(io.LimitReader(newReader(), size)), nil
+		},
+	}, nil
+}
+
+type patternReader struct {
+	pattern []byte
+	pos     int
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.pattern[r.pos]
+		r.pos = (r.pos + 1) % len(r.pattern)
+	}
+	return len(p), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+const randomTextAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type randomTextReader struct {
+	rnd *mathrand.Rand
+}
+
+func (r *randomTextReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = randomTextAlphabet[r.rnd.Intn(len(randomTextAlphabet))]
+	}
+	return len(p), nil
+}
+
+// BodyDigest counts and hashes the bytes written to it, the hash format matches the one reported by the server.
+type BodyDigest struct {
+	size int64
+	hash hash.Hash
+}
+
+func NewBodyDigest() *BodyDigest {
+	return &BodyDigest{hash: md5.New()}
+}
+
+func (d *BodyDigest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *BodyDigest) Size() int64 {
+	return d.size
+}
+
+func (d *BodyDigest) Hash() string {
+	if d.size == 0 {
+		return "empty"
+	}
+	return fmt.Sprintf("MD5:%x", d.hash.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Capture is a request received by the server, one JSON document per line of the capture file.
+// The raw lines keep their line terminators, so the request can be replayed byte-exact.
+type Capture struct {
+	Time           time.Time `json:"time"`
+	ConnectionId   uint64    `json:"connectionId"`
+	RemoteAddress  string    `json:"remoteAddress"`
+	LocalAddress   string    `json:"localAddress"`
+	RawStartLine   string    `json:"rawStartLine"`
+	RawHeaderLines []string  `json:"rawHeaderLines"`
+	RawHeaderEnd   string    `json:"rawHeaderEnd"`
+	// RawHead replaces the raw lines when they are not valid UTF-8, which JSON strings cannot keep.
+	RawHead []byte `json:"rawHead,omitempty"`
+	// Body is omitted when it exceeds the capture limit, the size and hash are kept anyway.
+	Body     []byte `json:"body,omitempty"`
+	BodySize int    `json:"bodySize"`
+	BodyHash string `json:"bodyHash"`
+	// BodyNotRead tells the body framed by Transfer-Encoding was not read by the server, so the request cannot be replayed.
+	BodyNotRead bool   `json:"bodyNotRead,omitempty"`
+	ReadMs      int64  `json:"readMs"`
+	SleepMs     int64  `json:"sleepMs"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Head returns the request line and the header section as they were received.
+func (c *Capture) Head() []byte {
+	if c.RawHead != nil {
+		return c.RawHead
+	}
+	return []byte(c.RawStartLine + strings.Join(c.RawHeaderLines, "") + c.RawHeaderEnd)
+}
+
+// Method returns the method of the captured request line.
+func (c *Capture) Method() string {
+	method, _, _ := strings.Cut(string(c.Head()), " ")
+	return method
+}
+
+// BodyCaptured reports whether the whole received body is kept.
+func (c *Capture) BodyCaptured() bool {
+	return !c.BodyNotRead && len(c.Body) == c.BodySize
+}
+
+// ReadCaptures loads the captured requests from the JSONL capture file.
+func ReadCaptures(path string) ([]Capture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening capture file: %v", err)
+	}
+	defer SafeClose(file)
+	var captures []Capture
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var capture Capture
+		if err := json.Unmarshal(scanner.Bytes(), &capture); err != nil {
+			return nil, fmt.Errorf("error parsing capture file line %d: %v", lineNumber, err)
+		}
+		captures = append(captures, capture)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading capture file: %v", err)
+	}
+	return captures, nil
+}
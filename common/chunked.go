@@ -0,0 +1,99 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ChunkedReader decodes a body sent with the chunked transfer coding, the chunk size lines
+// and the trailer lines are kept as they were received.
+type ChunkedReader struct {
+	reader    *bufio.Reader
+	remaining int64
+	done      bool
+	SizeLines []string
+	Trailers  []string
+}
+
+func NewChunkedReader(reader *bufio.Reader) *ChunkedReader {
+	return &ChunkedReader{reader: reader}
+}
+
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if r.remaining == 0 {
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining == 0 && err == nil {
+		err = r.readChunkEnd()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ChunkedReader) nextChunk() error {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	r.SizeLines = append(r.SizeLines, line)
+	sizeField, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid chunk size line: %q", line)
+	}
+	if size == 0 {
+		return r.readTrailers()
+	}
+	r.remaining = size
+	return nil
+}
+
+func (r *ChunkedReader) readChunkEnd() error {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if strings.TrimRight(line, "\r\n") != "" {
+		return fmt.Errorf("missing CRLF after chunk data: %q", line)
+	}
+	return nil
+}
+
+func (r *ChunkedReader) readTrailers() error {
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		if strings.TrimRight(line, "\r\n") == "" {
+			r.done = true
+			return nil
+		}
+		r.Trailers = append(r.Trailers, line)
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -11,6 +11,17 @@ import (
 )
 
 const SleepDurationQueryParamName = "rawh-sleep-duration"
+const FormatQueryParamName = "rawh-format"
+const DescribeBodyQueryParamName = "rawh-describe-body"
+const ExpectContinueQueryParamName = "rawh-expect-continue"
+const InterimQueryParamName = "rawh-interim"
+const SseQueryParamName = "rawh-sse"
+const SseIntervalQueryParamName = "rawh-sse-interval"
+const StreamQueryParamName = "rawh-stream"
+const StreamChunkSizeQueryParamName = "rawh-stream-chunk-size"
+const StreamIntervalQueryParamName = "rawh-stream-interval"
+const StreamTrailerQueryParamName = "rawh-stream-trailer"
+const StreamContentLengthQueryParamName = "rawh-stream-content-length"
 
 var tlsVersions = map[string]uint16{
 	"1.0": tls.VersionTLS10,
@@ -46,18 +57,22 @@ func ParseHttpVersionName(versionName string) (HttpVersion, error) {
 	return HttpVersion{fmt.Sprintf("HTTP/%s", versionName), 0, 0}, fmt.Errorf("unsupported HTTP version: %s", versionName)
 }
 
-func ExtractSleepDurationFromQuery(requestURI string) time.Duration {
+// ExtractQueryParam returns the first value of the query parameter, or an empty string when it is missing.
+func ExtractQueryParam(requestURI string, name string) string {
 	u, err := url.Parse(requestURI)
 	if err != nil {
 		log.Printf("Error parsing URL: %v", err)
-		return 0
+		return ""
 	}
 	values, err := url.ParseQuery(u.RawQuery)
 	if err != nil {
 		log.Printf("Error parsing query: %v", err)
-		return 0
 	}
-	sleepDurationStr := values.Get(SleepDurationQueryParamName)
+	return values.Get(name)
+}
+
+func ExtractSleepDurationFromQuery(requestURI string) time.Duration {
+	sleepDurationStr := ExtractQueryParam(requestURI, SleepDurationQueryParamName)
 	if sleepDurationStr == "" {
 		return 0
 	}
@@ -79,20 +94,6 @@ func (m *MultiString) Set(value string) error {
 	return nil
 }
 
-func GenerateSampleDataString(length int) string {
-	pattern := "1234567890"
-	patternLength := len(pattern)
-	if length <= 0 {
-		return ""
-	}
-	repeatCount := length / patternLength
-	if length%patternLength != 0 {
-		repeatCount++
-	}
-	repeatedString := strings.Repeat(pattern, repeatCount)
-	return repeatedString[:length]
-}
-
 func SafeClose(closable io.ReadCloser) {
 	err := closable.Close()
 	if err != nil {
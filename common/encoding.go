@@ -0,0 +1,61 @@
+package common
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+var SupportedContentEncodings = []string{"gzip", "x-gzip", "deflate", "br"}
+
+// ParseContentEncodings returns the content codings in the order they have to be decoded, 'identity' is skipped.
+func ParseContentEncodings(headerValue string) []string {
+	var encodings []string
+	for _, part := range strings.Split(headerValue, ",") {
+		encoding := strings.ToLower(strings.TrimSpace(part))
+		if encoding != "" && encoding != "identity" {
+			encodings = append([]string{encoding}, encodings...)
+		}
+	}
+	return encodings
+}
+
+// NewDecodingReader decodes the content codings in the given order.
+func NewDecodingReader(r io.Reader, encodings []string) (io.Reader, error) {
+	for _, encoding := range encodings {
+		var err error
+		switch encoding {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = newDeflateReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		default:
+			err = fmt.Errorf("unsupported content coding")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding '%s' content: %v", encoding, err)
+		}
+	}
+	return r, nil
+}
+
+// newDeflateReader accepts both the zlib wrapped stream required by HTTP and the raw deflate stream sent by some servers.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
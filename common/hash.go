@@ -0,0 +1,206 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"math/bits"
+	"strings"
+)
+
+var SupportedHashAlgorithms = []string{"md5", "sha1", "sha256", "crc32c", "xxhash"}
+
+// hashLabels are the prefixes of the reported hashes, 'MD5' keeps the format used by the client and the capture file.
+var hashLabels = map[string]string{
+	"md5":    "MD5",
+	"sha1":   "SHA-1",
+	"sha256": "SHA-256",
+	"crc32c": "CRC32C",
+	"xxhash": "XXH64",
+}
+
+// ParseHashAlgorithms validates the algorithm names, they are returned in lower case without duplicates.
+func ParseHashAlgorithms(names []string) ([]string, error) {
+	var algorithms []string
+	for _, name := range names {
+		algorithm := strings.ToLower(strings.TrimSpace(name))
+		if _, ok := hashLabels[algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm '%s', supported: %s", name, strings.Join(SupportedHashAlgorithms, ", "))
+		}
+		if !containsString(algorithms, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("no hash algorithm selected, supported: %s", strings.Join(SupportedHashAlgorithms, ", "))
+	}
+	return algorithms, nil
+}
+
+// MultiHash counts the bytes written to it and hashes them with all the selected algorithms at once.
+type MultiHash struct {
+	size       int64
+	algorithms []string
+	hashes     []hash.Hash
+}
+
+// NewMultiHash expects the algorithms validated by ParseHashAlgorithms, MD5 is used when none is given.
+func NewMultiHash(algorithms []string) *MultiHash {
+	if len(algorithms) == 0 {
+		algorithms = []string{"md5"}
+	}
+	m := &MultiHash{algorithms: algorithms}
+	for _, algorithm := range algorithms {
+		m.hashes = append(m.hashes, newHash(algorithm))
+	}
+	return m
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "xxhash":
+		return newXxHash64()
+	default:
+		return md5.New()
+	}
+}
+
+func (m *MultiHash) Write(p []byte) (int, error) {
+	m.size += int64(len(p))
+	for _, h := range m.hashes {
+		_, _ = h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (m *MultiHash) Size() int64 {
+	return m.size
+}
+
+// Hashes returns the hashes in the order of the algorithms, or the single 'empty' when nothing was written.
+func (m *MultiHash) Hashes() []string {
+	if m.size == 0 {
+		return []string{"empty"}
+	}
+	hashes := make([]string, len(m.hashes))
+	for i, h := range m.hashes {
+		hashes[i] = fmt.Sprintf("%s:%x", hashLabels[m.algorithms[i]], h.Sum(nil))
+	}
+	return hashes
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 is the streaming XXH64 hash with the zero seed, as reported by the 'xxhsum' tool.
+type xxHash64 struct {
+	v     [4]uint64
+	total uint64
+	buf   [32]byte
+	n     int
+}
+
+func newXxHash64() *xxHash64 {
+	h := &xxHash64{}
+	h.Reset()
+	return h
+}
+
+func (h *xxHash64) Reset() {
+	prime1 := xxPrime1 // the variable lets the sums wrap around
+	h.v = [4]uint64{prime1 + xxPrime2, xxPrime2, 0, -prime1}
+	h.total, h.n = 0, 0
+}
+
+func (h *xxHash64) Size() int {
+	return 8
+}
+
+func (h *xxHash64) BlockSize() int {
+	return 32
+}
+
+func (h *xxHash64) Write(p []byte) (int, error) {
+	written := len(p)
+	h.total += uint64(written)
+	if h.n > 0 {
+		copied := copy(h.buf[h.n:], p)
+		h.n += copied
+		p = p[copied:]
+		if h.n < 32 {
+			return written, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return written, nil
+}
+
+func (h *xxHash64) stripe(p []byte) {
+	for i := range h.v {
+		h.v[i] = xxRound(h.v[i], binary.LittleEndian.Uint64(p[i*8:]))
+	}
+}
+
+func (h *xxHash64) Sum(b []byte) []byte {
+	var sum uint64
+	if h.total >= 32 {
+		sum = bits.RotateLeft64(h.v[0], 1) + bits.RotateLeft64(h.v[1], 7) + bits.RotateLeft64(h.v[2], 12) + bits.RotateLeft64(h.v[3], 18)
+		for _, v := range h.v {
+			sum = (sum^xxRound(0, v))*xxPrime1 + xxPrime4
+		}
+	} else {
+		sum = h.v[2] + xxPrime5
+	}
+	sum += h.total
+	p := h.buf[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		sum = bits.RotateLeft64(sum^xxRound(0, binary.LittleEndian.Uint64(p)), 27)*xxPrime1 + xxPrime4
+	}
+	if len(p) >= 4 {
+		sum = bits.RotateLeft64(sum^uint64(binary.LittleEndian.Uint32(p))*xxPrime1, 23)*xxPrime2 + xxPrime3
+		p = p[4:]
+	}
+	for _, c := range p {
+		sum = bits.RotateLeft64(sum^uint64(c)*xxPrime5, 11) * xxPrime1
+	}
+	sum ^= sum >> 33
+	sum *= xxPrime2
+	sum ^= sum >> 29
+	sum *= xxPrime3
+	sum ^= sum >> 32
+	return binary.BigEndian.AppendUint64(b, sum)
+}
+
+func xxRound(acc uint64, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
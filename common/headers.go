@@ -12,9 +12,30 @@ import (
 const SleepDurationHeaderName = "Rawh-Sleep-Duration"
 const ContentLengthHeaderName = "Content-Length"
 const EchoHeaderName = "Rawh-Echo"
+const FormatHeaderName = "Rawh-Format"
+const DescribeBodyHeaderName = "Rawh-Describe-Body"
+const ExpectContinueHeaderName = "Rawh-Expect-Continue"
+const InterimHeaderName = "Rawh-Interim"
+const SseHeaderName = "Rawh-Sse"
+const SseIntervalHeaderName = "Rawh-Sse-Interval"
+const StreamHeaderName = "Rawh-Stream"
+const StreamChunkSizeHeaderName = "Rawh-Stream-Chunk-Size"
+const StreamIntervalHeaderName = "Rawh-Stream-Interval"
+const StreamTrailerHeaderName = "Rawh-Stream-Trailer"
+const StreamContentLengthHeaderName = "Rawh-Stream-Content-Length"
+const TransferEncodingHeaderName = "Transfer-Encoding"
+const TrailerHeaderName = "Trailer"
+const ContentEncodingHeaderName = "Content-Encoding"
+
+// HeaderField is a single header line kept in the order and case it was added.
+type HeaderField struct {
+	Name  string
+	Value string
+}
 
 type HttpHeaders struct {
 	normalizeHeaders bool
+	Fields           []HeaderField
 	HeadersData      map[string][]string
 	EchoHeadersData  map[string][]string
 	Host             string
@@ -39,6 +60,7 @@ func (h *HttpHeaders) Add(key, value string) {
 		key = textproto.CanonicalMIMEHeaderKey(key)
 	}
 	h.HeadersData[key] = append(h.HeadersData[key], value)
+	h.Fields = append(h.Fields, HeaderField{Name: key, Value: value})
 	lk := strings.ToLower(key)
 	if lk == "host" && value != "" {
 		h.Host = value
@@ -69,6 +91,27 @@ func (h *HttpHeaders) Add(key, value string) {
 	}
 }
 
+// Has reports whether a header with the name, compared case-insensitively, was added.
+func (h *HttpHeaders) Has(name string) bool {
+	for _, field := range h.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns the values of the headers with the name, compared case-insensitively, in the order they were added.
+func (h *HttpHeaders) Values(name string) []string {
+	var values []string
+	for _, field := range h.Fields {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
 func (h *HttpHeaders) AddLine(headerLine string) error {
 	key, val, err := SplitHeaderLine(headerLine)
 	if err == nil {
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Display controls how the raw protocol lines are shown in the verbose output.
+type Display struct {
+	// ShowInvisible renders the line terminators, tabs, trailing whitespace and non-printable bytes explicitly.
+	ShowInvisible bool
+	// HexDump adds the hex dump of every line.
+	HexDump bool
+}
+
+// Format returns the raw line for the verbose output, trimmed unless the invisible characters are shown.
+func (d Display) Format(line string) string {
+	if d.ShowInvisible {
+		return VisibleLine(line)
+	}
+	return strings.TrimSpace(line)
+}
+
+// HexDumpLines returns the hex dump of the raw line when it is enabled.
+func (d Display) HexDumpLines(line string) []string {
+	if !d.HexDump || line == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(hex.Dump([]byte(line)), "\n"), "\n")
+}
+
+// VisibleLine renders the invisible characters of the raw line explicitly: '\r', '\n' and '\t' escapes,
+// '·' for every trailing space and '\xNN' for the control bytes and every byte outside ASCII, including obs-text.
+// The backslash is escaped as '\\', so the rendering is unambiguous.
+func VisibleLine(line string) string {
+	content := strings.TrimRight(line, "\r\n")
+	trailing := len(content) - len(strings.TrimRight(content, " \t"))
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == ' ' && i >= len(content)-trailing && i < len(content):
+			b.WriteString("·")
+		case c < 0x20 || c >= 0x7f:
+			b.WriteString(fmt.Sprintf(`\x%02x`, c))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
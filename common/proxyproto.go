@@ -0,0 +1,343 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProxyProtocolOff      = "off"
+	ProxyProtocolOptional = "optional"
+	ProxyProtocolRequired = "required"
+)
+
+var ProxyProtocolModes = []string{ProxyProtocolOff, ProxyProtocolOptional, ProxyProtocolRequired}
+var ProxyProtocolVersions = []string{"auto", "1", "2"}
+
+// proxyV2Signature starts every PROXY protocol v2 header, it cannot start an HTTP request.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1HeaderSize is the longest v1 header line including CRLF (PROXY protocol specification section 2.1).
+const maxProxyV1HeaderSize = 107
+
+// proxyTLVNames are the registered v2 TLV types (PROXY protocol specification section 2.2.7).
+var proxyTLVNames = map[byte]string{
+	0x01: "ALPN",
+	0x02: "AUTHORITY",
+	0x03: "CRC32C",
+	0x04: "NOOP",
+	0x05: "UNIQUE_ID",
+	0x20: "SSL",
+	0x21: "SSL_VERSION",
+	0x22: "SSL_CN",
+	0x23: "SSL_CIPHER",
+	0x24: "SSL_SIG_ALG",
+	0x25: "SSL_KEY_ALG",
+	0x30: "NETNS",
+}
+
+// ProxyHeader is the decoded PROXY protocol header sent by a load balancer before the request.
+type ProxyHeader struct {
+	Version int `json:"version"`
+	// Command is 'PROXY' for the relayed connections and 'LOCAL' for the balancer's own health checks.
+	Command            string     `json:"command"`
+	Family             string     `json:"family"`
+	SourceAddress      string     `json:"sourceAddress,omitempty"`
+	DestinationAddress string     `json:"destinationAddress,omitempty"`
+	TLVs               []ProxyTLV `json:"tlvs,omitempty"`
+	// Raw is the header as it was received.
+	Raw []byte `json:"-"`
+}
+
+// ProxyTLV is a v2 header extension, the value is shown as text when printable and as hex otherwise.
+type ProxyTLV struct {
+	Type  byte       `json:"type"`
+	Name  string     `json:"name"`
+	Value string     `json:"value"`
+	Sub   []ProxyTLV `json:"sub,omitempty"`
+	raw   []byte
+}
+
+func ValidateProxyProtocol(mode string, version string) error {
+	if !containsString(ProxyProtocolModes, mode) {
+		return fmt.Errorf("invalid PROXY protocol mode '%s', expected one of: %s", mode, strings.Join(ProxyProtocolModes, ", "))
+	}
+	if !containsString(ProxyProtocolVersions, version) {
+		return fmt.Errorf("invalid PROXY protocol version '%s', expected one of: %s", version, strings.Join(ProxyProtocolVersions, ", "))
+	}
+	return nil
+}
+
+// ReadProxyHeader reads the PROXY protocol header of the accepted version ('auto', '1' or '2') when the connection starts with one,
+// nil is returned when it does not and nothing is consumed then.
+func ReadProxyHeader(r *bufio.Reader, version string) (*ProxyHeader, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case first[0] == 'P' && version != "2" && peekPrefix(r, []byte("PROXY ")):
+		return readProxyHeaderV1(r)
+	case first[0] == '\r' && version != "1" && peekPrefix(r, proxyV2Signature):
+		return readProxyHeaderV2(r)
+	}
+	return nil, nil
+}
+
+// peekPrefix compares the buffered bytes with the prefix, a shorter message is compared as far as it goes.
+func peekPrefix(r *bufio.Reader, prefix []byte) bool {
+	data, _ := r.Peek(len(prefix))
+	return len(data) == len(prefix) && bytes.Equal(data, prefix)
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < maxProxyV1HeaderSize {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading PROXY v1 header: %v", err)
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 header is not terminated by CRLF within %d bytes: %q", maxProxyV1HeaderSize, line)
+	}
+	header := &ProxyHeader{Version: 1, Command: "PROXY", Raw: line}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	header.Family = fields[1]
+	switch header.Family {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("PROXY v1 header has %d fields instead of 6: %q", len(fields), line)
+		}
+		source, err := proxyV1Address(fields[2], fields[4])
+		if err != nil {
+			return nil, err
+		}
+		destination, err := proxyV1Address(fields[3], fields[5])
+		if err != nil {
+			return nil, err
+		}
+		header.SourceAddress, header.DestinationAddress = source, destination
+		return header, nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY v1 protocol family '%s'", header.Family)
+}
+
+func proxyV1Address(ip string, port string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("invalid PROXY v1 address '%s': %v", ip, err)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return "", fmt.Errorf("invalid PROXY v1 port '%s'", port)
+	}
+	return netip.AddrPortFrom(addr, uint16(portNumber)).String(), nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("error reading PROXY v2 header: %v", err)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY v2 header version %d", fixed[12]>>4)
+	}
+	header := &ProxyHeader{Version: 2}
+	switch fixed[12] & 0x0f {
+	case 0:
+		header.Command = "LOCAL"
+	case 1:
+		header.Command = "PROXY"
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", fixed[12]&0x0f)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("error reading PROXY v2 addresses: %v", err)
+	}
+	header.Raw = append(fixed, payload...)
+	var addressSize int
+	switch fixed[13] {
+	case 0x11, 0x12:
+		header.Family, addressSize = map[byte]string{0x11: "TCP4", 0x12: "UDP4"}[fixed[13]], 12
+	case 0x21, 0x22:
+		header.Family, addressSize = map[byte]string{0x21: "TCP6", 0x22: "UDP6"}[fixed[13]], 36
+	case 0x31, 0x32:
+		header.Family, addressSize = map[byte]string{0x31: "UNIX_STREAM", 0x32: "UNIX_DGRAM"}[fixed[13]], 216
+	default:
+		header.Family = "UNSPEC"
+	}
+	if len(payload) < addressSize {
+		return nil, fmt.Errorf("PROXY v2 address block of %d bytes is shorter than %d bytes required by %s", len(payload), addressSize, header.Family)
+	}
+	addresses := payload[:addressSize]
+	switch addressSize {
+	case 12, 36:
+		ipSize := addressSize/2 - 2
+		source, _ := netip.AddrFromSlice(addresses[:ipSize])
+		destination, _ := netip.AddrFromSlice(addresses[ipSize : 2*ipSize])
+		header.SourceAddress = netip.AddrPortFrom(source, binary.BigEndian.Uint16(addresses[2*ipSize:])).String()
+		header.DestinationAddress = netip.AddrPortFrom(destination, binary.BigEndian.Uint16(addresses[2*ipSize+2:])).String()
+	case 216:
+		header.SourceAddress = string(bytes.TrimRight(addresses[:108], "\x00"))
+		header.DestinationAddress = string(bytes.TrimRight(addresses[108:], "\x00"))
+	}
+	tlvs, err := parseProxyTLVs(payload[addressSize:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	return header, nil
+}
+
+func parseProxyTLVs(data []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, fmt.Errorf("truncated PROXY v2 TLV of %d bytes", len(data))
+		}
+		size := int(binary.BigEndian.Uint16(data[1:]))
+		if len(data) < 3+size {
+			return nil, fmt.Errorf("PROXY v2 TLV 0x%02x of %d bytes exceeds the header", data[0], size)
+		}
+		tlv := ProxyTLV{Type: data[0], Name: proxyTLVName(data[0]), raw: data[3 : 3+size]}
+		tlv.Value = proxyTLVValue(tlv.raw)
+		if tlv.Type == 0x20 && size >= 5 {
+			// PP2_TYPE_SSL: the client flags, the verify result and the SSL sub-TLVs
+			tlv.Value = fmt.Sprintf("client=0x%02x verify=%d", tlv.raw[0], binary.BigEndian.Uint32(tlv.raw[1:5]))
+			sub, err := parseProxyTLVs(tlv.raw[5:])
+			if err != nil {
+				return nil, err
+			}
+			tlv.Sub = sub
+		}
+		tlvs = append(tlvs, tlv)
+		data = data[3+size:]
+	}
+	return tlvs, nil
+}
+
+// TlsClient reports whether the balancer received the connection over TLS, the PP2_CLIENT_SSL flag of the PP2_TYPE_SSL TLV.
+func (h *ProxyHeader) TlsClient() bool {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == 0x20 && len(tlv.raw) > 0 && tlv.raw[0]&0x01 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyTLVName(tlvType byte) string {
+	if name, ok := proxyTLVNames[tlvType]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", tlvType)
+}
+
+func proxyTLVValue(value []byte) string {
+	for _, c := range value {
+		if c < 0x20 || c >= 0x7f {
+			return hex.EncodeToString(value)
+		}
+	}
+	return string(value)
+}
+
+// ParseProxyTLV parses the 'type=value' TLV specification, the type is a registered name like 'authority' or a number like '0xe0'.
+func ParseProxyTLV(spec string) (ProxyTLV, error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return ProxyTLV{}, fmt.Errorf("invalid PROXY TLV '%s', expected 'type=value'", spec)
+	}
+	for tlvType, tlvName := range proxyTLVNames {
+		if strings.EqualFold(tlvName, name) {
+			return ProxyTLV{Type: tlvType, Name: tlvName, Value: value, raw: []byte(value)}, nil
+		}
+	}
+	tlvType, err := strconv.ParseUint(name, 0, 8)
+	if err != nil {
+		return ProxyTLV{}, fmt.Errorf("invalid PROXY TLV type '%s'", name)
+	}
+	return ProxyTLV{Type: byte(tlvType), Name: proxyTLVName(byte(tlvType)), Value: value, raw: []byte(value)}, nil
+}
+
+// EncodeProxyHeader builds the PROXY header of the version for the TCP connection, the TLVs are sent by v2 only.
+func EncodeProxyHeader(version int, source netip.AddrPort, destination netip.AddrPort, tlvs []ProxyTLV) ([]byte, error) {
+	source = netip.AddrPortFrom(source.Addr().Unmap(), source.Port())
+	destination = netip.AddrPortFrom(destination.Addr().Unmap(), destination.Port())
+	if source.Addr().Is4() != destination.Addr().Is4() {
+		return nil, fmt.Errorf("PROXY header addresses %s and %s are of different families", source, destination)
+	}
+	switch version {
+	case 1:
+		family := "TCP4"
+		if source.Addr().Is6() {
+			family = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, source.Addr(), destination.Addr(), source.Port(), destination.Port())), nil
+	case 2:
+		var payload []byte
+		family := byte(0x11)
+		if source.Addr().Is6() {
+			family = 0x21
+		}
+		payload = append(payload, source.Addr().AsSlice()...)
+		payload = append(payload, destination.Addr().AsSlice()...)
+		payload = binary.BigEndian.AppendUint16(payload, source.Port())
+		payload = binary.BigEndian.AppendUint16(payload, destination.Port())
+		for _, tlv := range tlvs {
+			payload = append(payload, tlv.Type)
+			payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.raw)))
+			payload = append(payload, tlv.raw...)
+		}
+		header := append(append([]byte{}, proxyV2Signature...), 0x21, family)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+		return append(header, payload...), nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+}
+
+// AddrPort returns the IP address and port of the TCP address.
+func AddrPort(addr net.Addr) (netip.AddrPort, error) {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid TCP address '%s': %v", addr, err)
+	}
+	return addrPort, nil
+}
+
+// String describes the header in one line, like 'v2 PROXY TCP4 192.0.2.1:51234 -> 192.0.2.10:443'.
+func (h *ProxyHeader) String() string {
+	description := fmt.Sprintf("v%d %s %s", h.Version, h.Command, h.Family)
+	if h.SourceAddress != "" || h.DestinationAddress != "" {
+		description += fmt.Sprintf(" %s -> %s", h.SourceAddress, h.DestinationAddress)
+	}
+	return description
+}
+
+// TLVLines describes the TLVs one per line, the SSL sub-TLVs are indented.
+func (h *ProxyHeader) TLVLines() []string {
+	var lines []string
+	for _, tlv := range h.TLVs {
+		lines = append(lines, fmt.Sprintf("%s: %s", tlv.Name, tlv.Value))
+		for _, sub := range tlv.Sub {
+			lines = append(lines, fmt.Sprintf("  %s: %s", sub.Name, sub.Value))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,197 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// WebSocketGuid is appended to the Sec-WebSocket-Key to compute the Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const WebSocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	WebSocketOpContinuation = 0x0
+	WebSocketOpText         = 0x1
+	WebSocketOpBinary       = 0x2
+	WebSocketOpClose        = 0x8
+	WebSocketOpPing         = 0x9
+	WebSocketOpPong         = 0xA
+)
+
+var webSocketOpcodeNames = map[byte]string{
+	WebSocketOpContinuation: "continuation",
+	WebSocketOpText:         "text",
+	WebSocketOpBinary:       "binary",
+	WebSocketOpClose:        "close",
+	WebSocketOpPing:         "ping",
+	WebSocketOpPong:         "pong",
+}
+
+// WebSocketOpcodeName returns the opcode name, the reserved opcodes are named by their number.
+func WebSocketOpcodeName(opcode byte) string {
+	if name, ok := webSocketOpcodeNames[opcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("reserved-0x%X", opcode)
+}
+
+// WebSocketAccept returns the Sec-WebSocket-Accept value of the Sec-WebSocket-Key.
+func WebSocketAccept(key string) string {
+	digest := sha1.Sum([]byte(key + WebSocketGuid))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// NewWebSocketKey returns a random Sec-WebSocket-Key.
+func NewWebSocketKey() string {
+	key := make([]byte, 16)
+	_, _ = rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// WebSocketFrame is a frame as it was received or is to be sent (RFC 6455 section 5.2), the payload is unmasked.
+type WebSocketFrame struct {
+	Fin     bool
+	Rsv     byte
+	Opcode  byte
+	Masked  bool
+	MaskKey [4]byte
+	Payload []byte
+	// Length is the declared payload length, the payload is not read when it exceeds the limit.
+	Length uint64
+}
+
+// IsControl reports whether the frame is a close, ping or pong frame, which cannot be fragmented.
+func (f *WebSocketFrame) IsControl() bool {
+	return f.Opcode&0x8 != 0
+}
+
+// ReadWebSocketFrame reads the next frame, the payload larger than the limit is not read and an error is returned.
+func ReadWebSocketFrame(r io.Reader, maxPayload int) (*WebSocketFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &WebSocketFrame{Fin: head[0]&0x80 != 0, Rsv: head[0] >> 4 & 0x7, Opcode: head[0] & 0xF, Masked: head[1]&0x80 != 0}
+	f.Length = uint64(head[1] & 0x7F)
+	switch f.Length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return nil, err
+		}
+		f.Length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return nil, err
+		}
+		f.Length = binary.BigEndian.Uint64(extended[:])
+	}
+	if f.Masked {
+		if _, err := io.ReadFull(r, f.MaskKey[:]); err != nil {
+			return nil, err
+		}
+	}
+	if f.Length > uint64(maxPayload) {
+		return f, fmt.Errorf("frame payload of %d bytes exceeds the limit of %d bytes", f.Length, maxPayload)
+	}
+	f.Payload = make([]byte, f.Length)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		maskPayload(f.Payload, f.MaskKey)
+	}
+	return f, nil
+}
+
+// WriteWebSocketFrame sends the frame in one write, the payload is masked with a random key when the frame is masked.
+func WriteWebSocketFrame(w io.Writer, f *WebSocketFrame) error {
+	head := []byte{f.Rsv<<4 | f.Opcode, 0}
+	if f.Fin {
+		head[0] |= 0x80
+	}
+	length := len(f.Payload)
+	switch {
+	case length < 126:
+		head[1] = byte(length)
+	case length <= 0xFFFF:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+	payload := f.Payload
+	if f.Masked {
+		head[1] |= 0x80
+		_, _ = rand.Read(f.MaskKey[:])
+		head = append(head, f.MaskKey[:]...)
+		payload = append([]byte{}, f.Payload...)
+		maskPayload(payload, f.MaskKey)
+	}
+	f.Length = uint64(length)
+	_, err := w.Write(append(head, payload...))
+	return err
+}
+
+func maskPayload(payload []byte, key [4]byte) {
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+// NewWebSocketClosePayload returns the close frame payload with the status code and the reason, a zero code sends none.
+func NewWebSocketClosePayload(code int, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// ParseWebSocketClosePayload returns the status code and the reason of the close frame, 0 when there is no code.
+func ParseWebSocketClosePayload(payload []byte) (int, string) {
+	if len(payload) < 2 {
+		return 0, ""
+	}
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+// Describe returns the one line description of the frame header and its payload.
+func (f *WebSocketFrame) Describe() string {
+	parts := []string{WebSocketOpcodeName(f.Opcode), fmt.Sprintf("fin=%t", f.Fin)}
+	if f.Rsv != 0 {
+		parts = append(parts, fmt.Sprintf("rsv=%03b", f.Rsv))
+	}
+	if f.Masked {
+		parts = append(parts, "mask="+hex.EncodeToString(f.MaskKey[:]))
+	} else {
+		parts = append(parts, "unmasked")
+	}
+	parts = append(parts, fmt.Sprintf("length=%d", f.Length))
+	if f.Payload == nil && f.Length > 0 {
+		return strings.Join(parts, " ")
+	}
+	switch {
+	case f.Opcode == WebSocketOpClose:
+		if code, reason := ParseWebSocketClosePayload(f.Payload); code != 0 {
+			parts = append(parts, fmt.Sprintf("code=%d", code))
+			if reason != "" {
+				parts = append(parts, fmt.Sprintf("reason=%q", reason))
+			}
+		}
+	case f.Opcode == WebSocketOpBinary:
+		parts = append(parts, "payload="+hex.EncodeToString(f.Payload[:min(len(f.Payload), 64)]))
+	case len(f.Payload) > 0 && utf8.Valid(f.Payload):
+		parts = append(parts, fmt.Sprintf("payload=%q", string(f.Payload[:min(len(f.Payload), 256)])))
+	case len(f.Payload) > 0:
+		parts = append(parts, "payload="+hex.EncodeToString(f.Payload[:min(len(f.Payload), 64)]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MarshalYaml encodes the value as a YAML document, the struct fields are named and ordered as in the JSON encoding.
+// Strings are always double-quoted, so no value is mistaken for a number, a boolean or a YAML indicator,
+// the values implementing encoding.TextMarshaler, e.g. time.Time, are written as their text.
+func MarshalYaml(value any) string {
+	var b strings.Builder
+	writeYamlValue(&b, reflect.ValueOf(value), 0, true)
+	return strings.TrimPrefix(b.String(), " ") // a top level scalar or empty collection
+}
+
+func writeYamlValue(b *strings.Builder, v reflect.Value, indent int, inline bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			b.WriteString(" null\n")
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct && v.CanInterface() {
+		if marshaler, ok := v.Interface().(encoding.TextMarshaler); ok {
+			if text, err := marshaler.MarshalText(); err == nil {
+				b.WriteString(" " + strconv.Quote(string(text)) + "\n")
+				return
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(v)
+		if len(fields) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		if !inline {
+			b.WriteString("\n")
+		}
+		for i, field := range fields {
+			if i > 0 || !inline {
+				b.WriteString(strings.Repeat("  ", indent))
+			}
+			b.WriteString(field.name + ":")
+			writeYamlValue(b, field.value, indent+1, false)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		if !inline {
+			b.WriteString("\n")
+		}
+		for i := 0; i < v.Len(); i++ {
+			b.WriteString(strings.Repeat("  ", indent) + "-")
+			item := v.Index(i)
+			for item.Kind() == reflect.Pointer && !item.IsNil() {
+				item = item.Elem()
+			}
+			if item.Kind() == reflect.Struct && len(yamlFields(item)) > 0 {
+				b.WriteString(" ")
+				writeYamlValue(b, item, indent+1, true)
+			} else {
+				writeYamlValue(b, item, indent+1, false)
+			}
+		}
+	case reflect.Map:
+		if v.Len() == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		if !inline {
+			b.WriteString("\n")
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			b.WriteString(strings.Repeat("  ", indent) + strconv.Quote(fmt.Sprint(key)) + ":")
+			writeYamlValue(b, v.MapIndex(key), indent+1, false)
+		}
+	case reflect.String:
+		b.WriteString(" " + strconv.Quote(v.String()) + "\n")
+	case reflect.Bool:
+		b.WriteString(" " + strconv.FormatBool(v.Bool()) + "\n")
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(" " + strconv.FormatFloat(v.Float(), 'f', -1, 64) + "\n")
+	default:
+		b.WriteString(fmt.Sprintf(" %v\n", v.Interface()))
+	}
+}
+
+type yamlField struct {
+	name  string
+	value reflect.Value
+}
+
+// yamlFields returns the exported fields with their JSON names, skipping the fields the JSON encoding omits.
+func yamlFields(v reflect.Value) []yamlField {
+	var fields []yamlField
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		if !structField.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+		value := v.Field(i)
+		if strings.Contains(options, "omitempty") && value.IsZero() {
+			continue
+		}
+		if (value.Kind() == reflect.Slice || value.Kind() == reflect.Map) && value.Len() == 0 && strings.Contains(options, "omitempty") {
+			continue
+		}
+		fields = append(fields, yamlField{name: name, value: value})
+	}
+	return fields
+}
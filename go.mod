@@ -1,8 +1,9 @@
 module rawh
 
-go 1.21
+go 1.22
 
 require (
+	github.com/andybalholm/brotli v1.2.6
 	github.com/spf13/cobra v1.8.0
 	golang.org/x/net v0.26.0
 )
@@ -3,15 +3,18 @@ package main
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"log"
 	"os"
 	"rawh/client"
 	"rawh/common"
+	"rawh/proxy"
 	"rawh/server"
 	"strings"
+	"time"
 )
 
 var name = "rawh"
-var description = "rawh functions either as an HTTP server or as a client to diagnose requests and responses."
+var description = "rawh functions as an HTTP server, a client or a forward proxy to diagnose requests and responses."
 var version = "dev"
 
 func main() {
@@ -33,21 +36,61 @@ func main() {
 	rootCmd.PersistentFlags().BoolP("version", "V", false, "Displays the application version.")
 
 	var verbose bool
-	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enables verbose output for the operation (client and server modes).")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enables verbose output for the operation (client, server and proxy modes).")
+	var display common.Display
+	rootCmd.PersistentFlags().BoolVar(&display.ShowInvisible, "show-invisible", false, "Shows CR, LF, tabs, trailing whitespace and non-printable bytes of the raw lines explicitly (verbose output and text echo).")
+	rootCmd.PersistentFlags().BoolVar(&display.HexDump, "hex-dump", false, "Adds the hex dump of every received raw line to the verbose output.")
 
 	// Server commands
 	var serverPort int
+	var serverOptions server.Options
+	var captureMaxBodySize string
+	var maxBodySize string
+	var bodyHashes []string
 	var serverCmd = &cobra.Command{
 		Use:   "server",
 		Short: "Run as an HTTP server",
 		Run: func(cmd *cobra.Command, args []string) {
-			err := server.NewServer(serverPort, verbose).Serve()
+			byteSize, err := common.ParsePrittyByteSize(captureMaxBodySize)
+			if err != nil {
+				exitWithError(err)
+			}
+			serverOptions.CaptureMaxBodySize = byteSize
+			if serverOptions.MaxBodySize, err = common.ParsePrittyByteSize(maxBodySize); err != nil {
+				exitWithError(err)
+			}
+			if serverOptions.BodyHashes, err = common.ParseHashAlgorithms(bodyHashes); err != nil {
+				exitWithError(err)
+			}
+			serverOptions.Display = display
+			err = server.NewServer(serverPort, verbose, serverOptions).Serve()
 			if err != nil {
 				exitWithError(err)
 			}
 		},
 	}
 	serverCmd.Flags().IntVarP(&serverPort, "port", "p", 8080, "Specify the port the server will listen on")
+	serverCmd.Flags().DurationVar(&serverOptions.ReadHeaderTimeout, "read-header-timeout", 0, "Maximum duration of reading the request line and headers, responds with 408 when exceeded (0 disables it).")
+	serverCmd.Flags().DurationVar(&serverOptions.ReadBodyTimeout, "read-body-timeout", 0, "Maximum duration of reading the request body, responds with 408 when exceeded (0 disables it).")
+	serverCmd.Flags().BoolVar(&serverOptions.Strict, "strict", false, "Rejects the requests breaking the RFC 9110 and RFC 9112 message syntax with 400 explaining the broken rule, by default they are accepted with a logged warning.")
+	serverCmd.Flags().BoolVar(&serverOptions.KeepAlive, "keep-alive", true, "Serve the next requests of the persistent connections, the echo responses are framed by Content-Length.")
+	serverCmd.Flags().DurationVar(&serverOptions.IdleTimeout, "idle-timeout", 30*time.Second, "Maximum wait for the next request on a persistent connection (0 disables it).")
+	serverCmd.Flags().DurationVar(&serverOptions.SseInterval, "sse-interval", time.Second, "Interval of the Server-Sent Events sent to the requests accepting text/event-stream, the 'rawh-sse-interval' query parameter or header overrides it.")
+	serverCmd.Flags().IntVar(&serverOptions.SseCount, "sse-count", 0, "Number of the Server-Sent Events (0 streams them until the client disconnects), the 'rawh-sse' query parameter or header overrides it.")
+	serverCmd.Flags().StringVar(&maxBodySize, "max-body-size", "0", "Maximum size [B|KB|MB|GB] of the request body, larger requests are rejected with 413 (0 disables the limit).")
+	serverCmd.Flags().StringSliceVar(&bodyHashes, "body-hash", []string{"md5"}, "Algorithms hashing the request body, reported together: "+strings.Join(common.SupportedHashAlgorithms, ", ")+".")
+	serverCmd.Flags().StringVar(&serverOptions.ProxyProtocol, "proxy-protocol", common.ProxyProtocolOff, "Reads the PROXY protocol header sent by a load balancer before the request (options: off, optional, required - connections without it are dropped).")
+	serverCmd.Flags().StringVar(&serverOptions.ProxyProtocolVersion, "proxy-protocol-version", "auto", "Accepted PROXY protocol version (options: auto - detects v1 and v2, 1, 2).")
+	serverCmd.Flags().StringVar(&serverOptions.ExpectContinue, "expect-continue", server.ExpectContinueImmediate, "Answer to 'Expect: 100-continue' (options: immediate, never, a delay like '2s', a 4xx status like '417'), the 'rawh-expect-continue' query parameter or header overrides it.")
+	serverCmd.Flags().StringArrayVar(&serverOptions.InterimResponses, "interim", nil, "Sends the 1xx response before the final one, format '<status>[ <reason>][|<header line>]...', e.g. '103 Early Hints|Link: </style.css>; rel=preload' (repeatable).")
+	serverCmd.Flags().DurationVar(&serverOptions.InterimDelay, "interim-delay", 0, "Delay after every interim response.")
+	serverCmd.Flags().StringVar(&serverOptions.TlsCertFile, "tls-cert", "", "Certificate file (PEM) of the TLS listener, requires '--tls-key'.")
+	serverCmd.Flags().StringVar(&serverOptions.TlsKeyFile, "tls-key", "", "Private key file (PEM) of the TLS listener, requires '--tls-cert'.")
+	serverCmd.Flags().StringVar(&serverOptions.CaptureFile, "capture-file", "", "Appends every received request to the file as a JSON line, e.g. 'requests.jsonl', to be replayed with 'rawh replay'.")
+	serverCmd.Flags().StringVar(&captureMaxBodySize, "capture-max-body-size", "1MB", "Maximum size [B|KB|MB|GB] of the request body kept in the capture file, larger bodies are described by the size and hash only.")
+	serverCmd.Flags().BoolVar(&serverOptions.Inspect, "inspect", false, "Enables the inspection API returning the last received requests at the '--inspect-prefix' paths.")
+	serverCmd.Flags().StringVar(&serverOptions.InspectPrefix, "inspect-prefix", server.DefaultInspectPrefix, "Path prefix of the inspection API: '{prefix}/requests', '{prefix}/requests/{id}' and '{prefix}/stats'.")
+	serverCmd.Flags().IntVar(&serverOptions.InspectBufferSize, "inspect-buffer", 100, "Number of the last requests kept by the inspection API.")
 	rootCmd.AddCommand(serverCmd)
 
 	// Client commands
@@ -60,35 +103,90 @@ func main() {
 	var normalizeHeaders bool
 	var data string
 	var generateDataSize string
+	var generateDataType string
+	var generateDataSeed int64
+	var generateDataPattern string
+	var options client.Options
+	var harOutput string
+	var harInput string
+	var harEntries []int
 	var clientCmd = &cobra.Command{
 		Use:   "client <url>",
 		Short: "Run as an HTTP client",
-		Args:  cobra.ExactArgs(1), // Requires exactly one argument - url
+		Args: func(cmd *cobra.Command, args []string) error {
+			if harInput != "" {
+				return cobra.MaximumNArgs(1)(cmd, args) // the url replaces the recorded scheme and host
+			}
+			return cobra.ExactArgs(1)(cmd, args) // Requires exactly one argument - url
+		},
 		Run: func(cmd *cobra.Command, args []string) {
 
 			var httpClient client.Client
 			var err error
+			options.Display = display
+			if harOutput != "" {
+				options.Har = client.NewHarRecorder(harOutput, name, version)
+			}
+			if harInput != "" && canonical {
+				exitWithError(fmt.Errorf("the HAR replay uses the raw client to keep the recorded headers, '--canonical' is not supported"))
+			}
+			if options.WebSocket.Enabled && canonical {
+				exitWithError(fmt.Errorf("the WebSocket handshake is sent verbatim by the raw client, '--canonical' is not supported"))
+			}
 			if canonical {
-				httpClient, err = client.NewCanonicalClient(tlsVersionName, insecure, httpVersionName, verbose)
+				httpClient, err = client.NewCanonicalClient(tlsVersionName, insecure, httpVersionName, verbose, options)
 			} else {
-				httpClient, err = client.NewRawClient(normalizeHeaders, tlsVersionName, insecure, httpVersionName, verbose)
+				httpClient, err = client.NewRawClient(normalizeHeaders, tlsVersionName, insecure, httpVersionName, verbose, options)
 			}
 			if err != nil {
 				exitWithError(err)
 			}
+			if harInput != "" {
+				target := ""
+				if len(args) > 0 {
+					target = completeURL(args[0])
+				}
+				requests, err := client.ReadHarRequests(harInput, harEntries, target)
+				if err != nil {
+					exitWithError(err)
+				}
+				failed := 0
+				for _, req := range requests {
+					log.Printf("# har entry %d: %s %s\n", req.Entry, req.Method, req.URL)
+					err = httpClient.DoRequest(req.Method, req.URL, req.Headers, req.Body)
+					if err != nil {
+						_, _ = fmt.Fprintf(os.Stderr, "har entry %d: %s\n", req.Entry, err)
+						failed++
+					}
+				}
+				if err := options.Har.Write(); err != nil {
+					exitWithError(err)
+				}
+				if failed > 0 {
+					exitWithError(fmt.Errorf("%d of %d replayed HAR entries failed", failed, len(requests)))
+				}
+				return
+			}
+			var body *common.Body
 			if generateDataSize != "" {
 				byteSize, err := common.ParsePrittyByteSize(generateDataSize)
 				if err != nil {
 					exitWithError(err)
-				} else {
-					data = common.GenerateSampleDataString(byteSize)
+				}
+				body, err = common.NewGeneratedBody(generateDataType, int64(byteSize), generateDataSeed, generateDataPattern)
+				if err != nil {
+					exitWithError(err)
+				}
+			} else {
+				body, err = common.ParseDataArg(data)
+				if err != nil {
+					exitWithError(err)
 				}
 			}
-			url := args[0]
-			if !strings.HasPrefix(url, "http") {
-				url = "https://" + url
+			err = httpClient.DoRequest(method, completeURL(args[0]), headers, body)
+			if harErr := options.Har.Write(); harErr != nil && err == nil {
+				err = harErr
 			}
-			err = httpClient.DoRequest(method, url, headers, data)
 			if err != nil {
 				exitWithError(err)
 			}
@@ -96,15 +194,101 @@ func main() {
 	}
 	clientCmd.Flags().BoolVarP(&canonical, "canonical", "C", false, "Specifies whether the 'canonical' client should be used; by default, the 'raw' client will be used.")
 	clientCmd.Flags().StringVarP(&method, "method", "X", "GET", "Specifies the HTTP method to use (e.g., 'GET', 'POST').")
-	clientCmd.Flags().StringVarP(&data, "data", "d", "", "Data to be sent as the body of the request, typically with 'POST'.")
+	clientCmd.Flags().StringVarP(&data, "data", "d", "", "Data to be sent as the body of the request, typically with 'POST'; '@file' reads it from a file, '@-' from stdin.")
 	clientCmd.Flags().StringVar(&generateDataSize, "generate-data-size", "", "Data size [B|KB|MB|GB] to be generated and sent as the body of the request, typically with 'POST'.")
+	clientCmd.Flags().StringVar(&generateDataType, "generate-data-type", common.GeneratorPattern, "Generator of the data (options: pattern, zeros, random, incompressible).")
+	clientCmd.Flags().Int64Var(&generateDataSeed, "generate-data-seed", 1, "Seed of the 'random' data generator.")
+	clientCmd.Flags().StringVar(&generateDataPattern, "generate-data-pattern", common.DefaultGeneratorPattern, "Pattern repeated by the 'pattern' data generator.")
 	clientCmd.Flags().StringVar(&httpVersionName, "http", "1.1", "Specifies the HTTP version to use (options: 1.0, 1.1, 2).")
 	clientCmd.Flags().StringVar(&tlsVersionName, "tls", "1.2", "Specifies the TLS version to use (options: 1.0, 1.1, 1.2, 1.3).")
 	clientCmd.Flags().BoolVarP(&insecure, "insecure", "k", false, "Allow insecure server connections.")
 	clientCmd.Flags().StringArrayVarP(&headers, "header", "H", nil, "Adds a header to the request, format 'key: value'.")
 	clientCmd.Flags().BoolVar(&normalizeHeaders, "normalize-headers", false, "Normalize header names format.")
+	clientCmd.Flags().BoolVar(&options.Chunked.Enabled, "chunked", false, "Sends the body with 'Transfer-Encoding: chunked' (raw client).")
+	clientCmd.Flags().StringVar(&options.Chunked.Sizes, "chunk-sizes", "1KB", "Chunk sizes: fixed '1KB', random range '10-100' or list '1,5,10' where the last size repeats.")
+	clientCmd.Flags().DurationVar(&options.Chunked.Delay, "chunk-delay", 0, "Delay between the chunks, e.g. '100ms'.")
+	clientCmd.Flags().StringVar(&options.Chunked.Extension, "chunk-extension", "", "Chunk extension added to every chunk size line, e.g. 'name=value'.")
+	clientCmd.Flags().StringArrayVar(&options.Chunked.Trailers, "trailer", nil, "Adds a trailer to the chunked body, format 'Key: value' (exact case).")
+	clientCmd.Flags().StringSliceVar(&options.Chunked.Malforms, "chunked-malform", nil, "Deliberately malforms the chunked body (options: invalid-hex, wrong-size, no-final-chunk, cl-and-te).")
+	clientCmd.Flags().IntVar(&options.Slow.DribbleBytes, "dribble-bytes", 0, "Sends the request in pieces of the given number of bytes (raw client).")
+	clientCmd.Flags().DurationVar(&options.Slow.DribbleInterval, "dribble-interval", time.Second, "Interval between the request pieces sent with '--dribble-bytes'.")
+	clientCmd.Flags().DurationVar(&options.Slow.PauseAfterRequestLine, "pause-after-request-line", 0, "Pauses the sending after the request line.")
+	clientCmd.Flags().DurationVar(&options.Slow.PauseAfterHeaders, "pause-after-headers", 0, "Pauses the sending after the header section.")
+	clientCmd.Flags().DurationVar(&options.Slow.PauseInBody, "pause-in-body", 0, "Pauses the sending in the body at the '--pause-in-body-offset'.")
+	clientCmd.Flags().Int64Var(&options.Slow.PauseInBodyOffset, "pause-in-body-offset", 0, "Body byte offset of the '--pause-in-body' pause.")
+	clientCmd.Flags().Int64Var(&options.Slow.AbortAt, "abort-at", -1, "Closes the connection after the given number of request bytes.")
+	clientCmd.Flags().Int64Var(&options.Slow.HalfCloseAt, "half-close-at", -1, "Closes the writing side of the connection after the given number of request bytes and reads the response.")
+	clientCmd.Flags().StringVarP(&options.Response.OutputFile, "output", "o", "", "Writes the response body to the file instead of the standard output.")
+	clientCmd.Flags().StringVar(&options.Response.Decompress, "decompress", client.DecompressOff, "Decodes the response body (options: off, auto - according to Content-Encoding, gzip, deflate, br).")
+	clientCmd.Flags().BoolVarP(&options.Redirect.Follow, "follow", "L", false, "Follows the 301, 302, 303, 307 and 308 redirects showing every hop.")
+	clientCmd.Flags().IntVar(&options.Redirect.MaxRedirects, "max-redirects", 10, "Maximum number of redirects followed with '--follow'.")
+	clientCmd.Flags().BoolVar(&options.Expect.Continue, "expect-continue", false, "Sends 'Expect: 100-continue' and waits for the interim response before sending the body (raw client).")
+	clientCmd.Flags().DurationVar(&options.Expect.Timeout, "expect-timeout", time.Second, "Time waited for '100 Continue' before the body is sent anyway (0 waits forever).")
+	clientCmd.Flags().BoolVar(&options.Sse.Enabled, "sse", false, "Requests the Server-Sent Events stream and reports whether an intermediary buffers, coalesces or delays the events sent by 'rawh server'.")
+	clientCmd.Flags().BoolVar(&options.WebSocket.Enabled, "websocket", false, "Performs the WebSocket opening handshake, adding the missing handshake headers after the custom ones, and exchanges the frames (raw client, ws:// and wss:// URLs are accepted).")
+	clientCmd.Flags().StringArrayVar(&options.WebSocket.Send, "ws-send", nil, "WebSocket input line sent after the handshake (repeatable), the standard input is read without it: a text line, or '/text <text>', '/binary <hex>', '/ping [payload]', '/pong [payload]', '/close [code [reason]]', '/wait <duration>'.")
+	clientCmd.Flags().DurationVar(&options.WebSocket.Wait, "ws-wait", time.Second, "Time waited for the WebSocket frames after the input ends and for the close reply.")
+	clientCmd.Flags().IntVar(&options.Sse.MaxEvents, "sse-events", 0, "Stops reading the event stream after the number of events (0 reads it until the server ends it).")
+	clientCmd.Flags().StringVar(&options.Proxy.URL, "proxy", "", "Sends the request through the upstream proxy 'http://[user:password@]host:port', 'https://...' or 'socks5://...', the credentials are sent to the proxy.")
+	clientCmd.Flags().StringVar(&options.Proxy.Mode, "proxy-mode", client.ProxyModeAuto, "Request form sent to the HTTP proxy (options: auto - absolute-form for http and CONNECT for https and WebSocket, absolute, connect - raw client).")
+	clientCmd.Flags().StringArrayVar(&options.Proxy.Headers, "proxy-header", nil, "Adds a header to the CONNECT or absolute-form request sent to the HTTP proxy, format 'Key: value'.")
+	clientCmd.Flags().IntVar(&options.ProxyProtocol.Version, "proxy-protocol", 0, "Sends the PROXY protocol header of the version 1 or 2 before the request, as a load balancer does (raw client).")
+	clientCmd.Flags().StringVar(&options.ProxyProtocol.Source, "proxy-protocol-source", "", "Client address 'ip:port' claimed by the PROXY header, the local address of the connection by default.")
+	clientCmd.Flags().StringArrayVar(&options.ProxyProtocol.TLVs, "proxy-protocol-tlv", nil, "Adds a TLV to the PROXY v2 header, format 'type=value', e.g. 'alpn=h2', 'authority=example.com' or '0xe0=value'.")
+	clientCmd.Flags().StringVar(&options.OutputFormat, "output-format", client.OutputFormatText, "Output format (options: text, json - one exchange document per line).")
+	clientCmd.Flags().StringVar(&harOutput, "har-output", "", "Writes the exchanges to the file as HAR 1.2.")
+	clientCmd.Flags().StringVar(&harInput, "har-input", "", "Replays the requests recorded in the HAR file with their header case and order, the optional url replaces the recorded scheme and host.")
+	clientCmd.Flags().IntSliceVar(&harEntries, "har-entry", nil, "Replays only the HAR entries with the given numbers, counted from 1.")
 	rootCmd.AddCommand(clientCmd)
 
+	// Replay commands
+	var replayOptions client.ReplayOptions
+	var replayClientOptions = client.Options{Slow: client.SlowOptions{AbortAt: -1, HalfCloseAt: -1}}
+	var replayCmd = &cobra.Command{
+		Use:   "replay <capture-file> <target-url>",
+		Short: "Resend the requests captured by the server byte-exact",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			replayClientOptions.Display = display
+			rawClient, err := client.NewRawClient(false, tlsVersionName, insecure, "1.1", verbose, replayClientOptions)
+			if err != nil {
+				exitWithError(err)
+			}
+			err = rawClient.Replay(args[0], completeURL(args[1]), replayOptions)
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+	replayCmd.Flags().StringVar(&tlsVersionName, "tls", "1.2", "Specifies the TLS version to use (options: 1.0, 1.1, 1.2, 1.3).")
+	replayCmd.Flags().BoolVarP(&insecure, "insecure", "k", false, "Allow insecure server connections.")
+	replayCmd.Flags().StringSliceVar(&replayOptions.Methods, "method", nil, "Replays only the requests with the given methods, e.g. 'POST,PUT'.")
+	replayCmd.Flags().StringVar(&replayOptions.Match, "match", "", "Replays only the requests which head (request line and headers) matches the regular expression.")
+	replayCmd.Flags().IntVar(&replayOptions.Limit, "limit", 0, "Maximum number of replayed requests (0 replays all).")
+	replayCmd.Flags().Float64Var(&replayOptions.Rate, "rate", 0, "Number of requests sent per second (0 sends them one after another).")
+	replayCmd.Flags().StringVar(&replayClientOptions.OutputFormat, "output-format", client.OutputFormatText, "Output format (options: text, json - one exchange document per line).")
+	rootCmd.AddCommand(replayCmd)
+
+	// Proxy commands
+	var proxyPort int
+	var proxyOptions proxy.Options
+	var proxyCmd = &cobra.Command{
+		Use:   "proxy",
+		Short: "Run as an HTTP forward proxy",
+		Run: func(cmd *cobra.Command, args []string) {
+			proxyOptions.Display = display
+			err := proxy.NewProxy(proxyPort, verbose, proxyOptions).Serve()
+			if err != nil {
+				exitWithError(err)
+			}
+		},
+	}
+	proxyCmd.Flags().IntVarP(&proxyPort, "port", "p", 3128, "Specify the port the proxy will listen on")
+	proxyCmd.Flags().StringVar(&proxyOptions.Auth, "auth", "", "Requires the 'user:password' Basic credentials in the Proxy-Authorization header, the requests without them are challenged with 407.")
+	proxyCmd.Flags().StringVar(&proxyOptions.AuthRealm, "auth-realm", "rawh", "Realm of the 407 Proxy-Authenticate challenge.")
+	proxyCmd.Flags().DurationVar(&proxyOptions.DialTimeout, "dial-timeout", 10*time.Second, "Maximum duration of connecting to the target (0 disables it).")
+	rootCmd.AddCommand(proxyCmd)
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -112,9 +296,17 @@ func main() {
 
 }
 
+// completeURL adds the default https scheme to the url given without one, the WebSocket schemes are kept.
+func completeURL(url string) string {
+	if !strings.HasPrefix(url, "http") && !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+		return "https://" + url
+	}
+	return url
+}
+
 func exitWithError(err error) {
 	if err != nil {
-		fmt.Println(name, version)
+		_, _ = fmt.Fprintln(os.Stderr, name, version)
 		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	} else {
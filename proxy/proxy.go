@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tunnelDrainTimeout limits the wait for the client to close its side of the tunnel after the target closed its side.
+const tunnelDrainTimeout = 5 * time.Second
+
+// hopByHopHeaders are not forwarded (RFC 9110 section 7.6.1), nor are the headers listed in the Connection header.
+var hopByHopHeaders = []string{"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authorization", "TE", "Upgrade"}
+
+type Proxy struct {
+	port        int
+	verbose     bool
+	options     Options
+	connections atomic.Uint64
+}
+
+// Options are the proxy behaviour settings beyond the listening port.
+type Options struct {
+	// Auth requires the 'user:password' Basic credentials in the Proxy-Authorization header, the requests without them get 407
+	// with the Proxy-Authenticate challenge of the AuthRealm.
+	Auth      string
+	AuthRealm string
+	// DialTimeout limits the connection to the target, 0 disables the limit.
+	DialTimeout time.Duration
+	// Display controls how the raw lines are shown in the log.
+	Display common.Display
+}
+
+func NewProxy(port int, verbose bool, options Options) *Proxy {
+	if options.AuthRealm == "" {
+		options.AuthRealm = "rawh"
+	}
+	return &Proxy{port: port, verbose: verbose, options: options}
+}
+
+// proxyRequest is the request head as the client sent it.
+type proxyRequest struct {
+	method      string
+	target      string
+	httpVersion string
+	headers     *common.HttpHeaders
+	headerLines []string
+}
+
+func (p *Proxy) Serve() error {
+	if p.options.Auth != "" && !strings.Contains(p.options.Auth, ":") {
+		return fmt.Errorf("invalid proxy credentials, expected 'user:password'")
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", p.port))
+	if err != nil {
+		return fmt.Errorf("error setting up TCP server: %v\n", err)
+	}
+	defer func(ln net.Listener) {
+		err := ln.Close()
+		if err != nil {
+			fmt.Printf("Error closing TCP listener: %v\n", err)
+		}
+	}(ln)
+	if p.options.Auth != "" {
+		log.Printf("Proxy authentication is required (Basic realm \"%s\")\n", p.options.AuthRealm)
+	}
+	log.Printf("Forward proxy is running on :%d\n", p.port)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %v\n", err)
+		}
+		go p.handleConnection(conn)
+	}
+}
+
+func (p *Proxy) logInfo(connectionId uint64, line string) {
+	log.Printf("# connection %d: %s\n", connectionId, line)
+}
+
+func (p *Proxy) logVerbose(connectionId uint64, line string) {
+	if p.verbose {
+		p.logInfo(connectionId, line)
+	}
+}
+
+// logLine shows the raw line, followed by its hex dump when enabled.
+func (p *Proxy) logLine(direction string, line string) {
+	log.Printf("%s %s\n", direction, p.options.Display.Format(line))
+	for _, dumpLine := range p.options.Display.HexDumpLines(line) {
+		log.Printf("# %s\n", dumpLine)
+	}
+}
+
+// handleConnection serves the requests of the client connection until a tunnel is established or a request is forwarded,
+// the requests rejected with 407 keep the connection, so the client can send the credentials on it.
+func (p *Proxy) handleConnection(conn net.Conn) {
+	defer func() {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Error closing connection: %v", err)
+		}
+	}()
+	connectionId := p.connections.Add(1)
+	p.logVerbose(connectionId, "accepted from "+conn.RemoteAddr().String())
+	reader := bufio.NewReader(conn)
+	for {
+		req, err := p.readRequestHead(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				p.logInfo(connectionId, fmt.Sprintf("error reading request: %v", err))
+			}
+			return
+		}
+		if !p.authorized(req) {
+			p.logInfo(connectionId, fmt.Sprintf("%s %s rejected with 407", req.method, req.target))
+			if !p.rejectUnauthorized(conn, reader, req) {
+				return
+			}
+			continue
+		}
+		if req.method == http.MethodConnect {
+			p.tunnel(connectionId, conn, reader, req)
+		} else {
+			p.forward(connectionId, conn, reader, req)
+		}
+		return
+	}
+}
+
+// readRequestHead reads the request line and the header lines, which are logged as they were received.
+func (p *Proxy) readRequestHead(reader *bufio.Reader) (*proxyRequest, error) {
+	startLine, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	p.logLine("<", startLine)
+	parts := strings.Fields(startLine)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed request line '%s'", strings.TrimSpace(startLine))
+	}
+	req := &proxyRequest{method: parts[0], target: parts[1], httpVersion: parts[2], headers: common.NewHttpHeaders(false)}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		p.logLine("<", line)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return req, nil
+		}
+		req.headerLines = append(req.headerLines, line)
+		if err := req.headers.AddLine(line); err != nil {
+			log.Printf("read header line '%s' error: %v", line, err)
+		}
+	}
+}
+
+func (p *Proxy) authorized(req *proxyRequest) bool {
+	if p.options.Auth == "" {
+		return true
+	}
+	for _, value := range req.headers.Values("Proxy-Authorization") {
+		scheme, credentials, _ := strings.Cut(strings.TrimSpace(value), " ")
+		if !strings.EqualFold(scheme, "Basic") {
+			continue
+		}
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials)); err == nil && string(decoded) == p.options.Auth {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectUnauthorized sends the 407 challenge after reading the request body, false is returned when the connection cannot continue.
+func (p *Proxy) rejectUnauthorized(conn net.Conn, reader *bufio.Reader, req *proxyRequest) bool {
+	keep := relayBody(io.Discard, reader, req.headers) == nil
+	message := "proxy authentication required\r\n"
+	lines := []string{
+		"HTTP/1.1 407 Proxy Authentication Required",
+		fmt.Sprintf("Proxy-Authenticate: Basic realm=\"%s\"", p.options.AuthRealm),
+		"Content-Type: text/plain",
+		fmt.Sprintf("Content-Length: %d", len(message)),
+	}
+	if !keep {
+		lines = append(lines, "Connection: close")
+	}
+	return p.writeHead(conn, lines, message) == nil && keep
+}
+
+// writeHead sends the response head and the short body generated by the proxy.
+func (p *Proxy) writeHead(w io.Writer, lines []string, body string) error {
+	var b strings.Builder
+	for _, line := range append(lines, "") {
+		if p.verbose {
+			p.logLine(">", line+"\r\n")
+		}
+		b.WriteString(line + "\r\n")
+	}
+	b.WriteString(body)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// errorResponse sends the error generated by the proxy, the connection is closed after it.
+func (p *Proxy) errorResponse(w io.Writer, statusCode int, message string) {
+	_ = p.writeHead(w, []string{
+		fmt.Sprintf("HTTP/1.1 %d %s", statusCode, http.StatusText(statusCode)),
+		"Content-Type: text/plain",
+		fmt.Sprintf("Content-Length: %d", len(message)+2),
+		"Connection: close",
+	}, message+"\r\n")
+}
+
+func (p *Proxy) dial(address string, useTls bool, serverName string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.options.DialTimeout}
+	if useTls {
+		return tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: serverName})
+	}
+	return dialer.Dial("tcp", address)
+}
+
+// tunnel connects to the CONNECT target and relays the bytes both ways until both sides are closed.
+func (p *Proxy) tunnel(connectionId uint64, conn net.Conn, reader *bufio.Reader, req *proxyRequest) {
+	start := time.Now()
+	if _, port, err := net.SplitHostPort(req.target); err != nil || port == "" {
+		p.errorResponse(conn, http.StatusBadRequest, "the CONNECT target must be the authority-form 'host:port', got '"+req.target+"'")
+		return
+	}
+	target, err := p.dial(req.target, false, "")
+	if err != nil {
+		p.logInfo(connectionId, fmt.Sprintf("CONNECT %s failed: %v", req.target, err))
+		p.errorResponse(conn, http.StatusBadGateway, fmt.Sprintf("error connecting to %s: %v", req.target, err))
+		return
+	}
+	defer common.SafeClose(target)
+	if err := p.writeHead(conn, []string{"HTTP/1.1 200 Connection Established"}, ""); err != nil {
+		return
+	}
+	p.logInfo(connectionId, fmt.Sprintf("tunnel to %s (%s) established", req.target, target.RemoteAddr()))
+	upstream := make(chan int64)
+	go func() {
+		n, _ := io.Copy(target, reader)
+		closeWrite(target)
+		upstream <- n
+	}()
+	downstream, _ := io.Copy(conn, target)
+	closeWrite(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(tunnelDrainTimeout))
+	p.logInfo(connectionId, fmt.Sprintf("tunnel to %s closed after %s, %d bytes sent, %d bytes received",
+		req.target, time.Since(start).Round(time.Millisecond), <-upstream, downstream))
+}
+
+func closeWrite(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+}
+
+// forward sends the absolute-form request to the target in the origin-form, without the hop-by-hop headers and with
+// 'Connection: close', and relays the response as it is; the client connection is closed after it.
+func (p *Proxy) forward(connectionId uint64, conn net.Conn, reader *bufio.Reader, req *proxyRequest) {
+	targetURL, err := url.Parse(req.target)
+	if err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") || targetURL.Host == "" {
+		p.errorResponse(conn, http.StatusBadRequest, "the forward proxy expects the absolute-form 'http://host/path' or CONNECT, got '"+req.target+"'")
+		return
+	}
+	address := targetURL.Host
+	if targetURL.Port() == "" {
+		address = net.JoinHostPort(targetURL.Hostname(), map[string]string{"http": "80", "https": "443"}[targetURL.Scheme])
+	}
+	target, err := p.dial(address, targetURL.Scheme == "https", targetURL.Hostname())
+	if err != nil {
+		p.logInfo(connectionId, fmt.Sprintf("%s %s failed: %v", req.method, req.target, err))
+		p.errorResponse(conn, http.StatusBadGateway, fmt.Sprintf("error connecting to %s: %v", address, err))
+		return
+	}
+	defer common.SafeClose(target)
+	lines := []string{fmt.Sprintf("%s %s %s", req.method, targetURL.RequestURI(), req.httpVersion)}
+	if !req.headers.Has("Host") {
+		lines = append(lines, "Host: "+targetURL.Host)
+	}
+	dropped := map[string]bool{}
+	for _, name := range append(append([]string{}, hopByHopHeaders...), headerTokens(req.headers, "Connection")...) {
+		dropped[strings.ToLower(name)] = true
+	}
+	for _, line := range req.headerLines {
+		name, _, _ := common.SplitHeaderLine(line)
+		if dropped[strings.ToLower(name)] {
+			p.logVerbose(connectionId, "hop-by-hop header not forwarded: "+line)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "Via: "+strings.TrimPrefix(req.httpVersion, "HTTP/")+" rawh", "Connection: close")
+	if err := p.writeHead(target, lines, ""); err != nil {
+		p.errorResponse(conn, http.StatusBadGateway, fmt.Sprintf("error sending the request to %s: %v", address, err))
+		return
+	}
+	if err := relayBody(target, reader, req.headers); err != nil {
+		p.logInfo(connectionId, fmt.Sprintf("error relaying the request body: %v", err))
+		return
+	}
+	targetReader := bufio.NewReader(target)
+	statusLine := ""
+	for {
+		line, err := targetReader.ReadString('\n')
+		if err != nil {
+			p.errorResponse(conn, http.StatusBadGateway, fmt.Sprintf("error reading the response of %s: %v", address, err))
+			return
+		}
+		if statusLine == "" {
+			statusLine = strings.TrimSpace(line)
+		}
+		if p.verbose {
+			p.logLine("<", line)
+		}
+		if _, err := io.WriteString(conn, line); err != nil || strings.TrimSpace(line) == "" {
+			break
+		}
+	}
+	n, _ := io.Copy(conn, targetReader)
+	p.logInfo(connectionId, fmt.Sprintf("%s %s answered with '%s', %d body bytes", req.method, req.target, statusLine, n))
+}
+
+// headerTokens returns the comma-separated tokens of the header values.
+func headerTokens(headers *common.HttpHeaders, name string) []string {
+	var tokens []string
+	for _, value := range headers.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// relayBody copies the request body framed by Content-Length or the chunked coding, the chunks are relayed as they are.
+func relayBody(w io.Writer, reader *bufio.Reader, headers *common.HttpHeaders) error {
+	for _, coding := range headerTokens(headers, common.TransferEncodingHeaderName) {
+		if strings.EqualFold(coding, "chunked") {
+			return relayChunks(w, reader)
+		}
+	}
+	if headers.ContentLength > 0 {
+		_, err := io.CopyN(w, reader, int64(headers.ContentLength))
+		return err
+	}
+	return nil
+}
+
+func relayChunks(w io.Writer, reader *bufio.Reader) error {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+		sizeSpec, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeSpec), 16, 64)
+		if err != nil {
+			return fmt.Errorf("invalid chunk size line '%s'", strings.TrimSpace(line))
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(w, reader, size+2); err != nil {
+			return err
+		}
+	}
+	for { // trailer section
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+	}
+}
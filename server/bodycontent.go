@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"rawh/common"
+	"strconv"
+	"strings"
+)
+
+// maxDecodedBodySize limits the decoded body, so a small compressed body or stacked codings cannot exhaust the memory.
+const maxDecodedBodySize = 16 * 1024 * 1024
+
+// maxPrettyJsonSize limits the pretty-printed JSON included in the echo response.
+const maxPrettyJsonSize = 64 * 1024
+
+// EchoBodyContent describes the body semantically, it is included when the client asks for it.
+type EchoBodyContent struct {
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	DecodedSize     int    `json:"decodedSize,omitempty"`
+	DecodedHash     string `json:"decodedHash,omitempty"`
+	// DecodedTruncated tells the decoded body exceeds the limit, the size, the hash and the description cover its first bytes.
+	DecodedTruncated bool   `json:"decodedTruncated,omitempty"`
+	DecodingError    string `json:"decodingError,omitempty"`
+	// Skipped explains why the body is not described, e.g. it was too large to be kept in memory.
+	Skipped   string           `json:"skipped,omitempty"`
+	MediaType string           `json:"mediaType,omitempty"`
+	Multipart *EchoMultipart   `json:"multipart,omitempty"`
+	Form      []EchoQueryParam `json:"form,omitempty"`
+	Json      *EchoJson        `json:"json,omitempty"`
+}
+
+// EchoMultipart describes a multipart body, LineEnding is 'CRLF', 'LF' or 'mixed'.
+type EchoMultipart struct {
+	Boundary     string     `json:"boundary"`
+	LineEnding   string     `json:"lineEnding"`
+	PreambleSize int        `json:"preambleSize"`
+	EpilogueSize int        `json:"epilogueSize"`
+	Closed       bool       `json:"closed"`
+	Parts        []EchoPart `json:"parts"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// EchoPart is a multipart body part, its header lines keep the exact case.
+type EchoPart struct {
+	HeaderLines []string `json:"headerLines"`
+	Name        string   `json:"name,omitempty"`
+	FileName    string   `json:"fileName,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+	Size        int      `json:"size"`
+	Hash        string   `json:"hash"`
+}
+
+// EchoJson reports whether the body is valid JSON, the pretty-printed form is left out when it is too large.
+type EchoJson struct {
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+	Pretty string `json:"pretty,omitempty"`
+}
+
+// describeBodyRequested reports whether the client asked for the body description with the query parameter or the header.
+func (r *RequestData) describeBodyRequested() bool {
+	value := common.ExtractQueryParam(r.requestURI, common.DescribeBodyQueryParamName)
+	if values := r.headers.Values(common.DescribeBodyHeaderName); value == "" && len(values) > 0 {
+		value = values[0]
+	}
+	requested, _ := strconv.ParseBool(strings.TrimSpace(value))
+	return requested
+}
+
+// describeBody decodes the body according to its Content-Encoding and Content-Type headers.
+func (r *RequestData) describeBody() *EchoBodyContent {
+	content := &EchoBodyContent{ContentEncoding: strings.Join(r.headers.Values(common.ContentEncodingHeaderName), ", ")}
+	body := r.body
+	if body == nil && r.bodySize > 0 {
+		content.Skipped = fmt.Sprintf("body of %d bytes exceeds the buffered size", r.bodySize)
+		return content
+	}
+	if encodings := common.ParseContentEncodings(content.ContentEncoding); len(encodings) > 0 {
+		decoded, truncated, err := decodeBody(body, encodings)
+		if err != nil {
+			content.DecodingError = err.Error()
+			return content
+		}
+		body, content.DecodedTruncated = decoded, truncated
+		content.DecodedSize, content.DecodedHash = len(body), bodyHash(body)
+	}
+	contentTypes := r.headers.Values("Content-Type")
+	if len(contentTypes) == 0 {
+		return content
+	}
+	mediaType, params, err := mime.ParseMediaType(contentTypes[0])
+	if err != nil {
+		content.MediaType = contentTypes[0]
+		return content
+	}
+	content.MediaType = mediaType
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		content.Multipart = parseMultipart(body, params["boundary"])
+	case mediaType == "application/x-www-form-urlencoded":
+		content.Form = parseQueryParams(string(body))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		content.Json = describeJson(body)
+	}
+	return content
+}
+
+// decodeBody decodes the body up to maxDecodedBodySize, true is returned when the decoded body is longer.
+func decodeBody(body []byte, encodings []string) ([]byte, bool, error) {
+	reader, err := common.NewDecodingReader(bytes.NewReader(body), encodings)
+	if err != nil {
+		return nil, false, err
+	}
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxDecodedBodySize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("error decoding body: %v", err)
+	}
+	if len(decoded) > maxDecodedBodySize {
+		return decoded[:maxDecodedBodySize], true, nil
+	}
+	return decoded, false, nil
+}
+
+func bodyHash(data []byte) string {
+	digest := common.NewBodyDigest()
+	_, _ = digest.Write(data)
+	return digest.Hash()
+}
+
+// parseMultipart splits the body at the boundary delimiters (RFC 2046 section 5.1.1) without normalizing anything,
+// both CRLF and bare LF line endings are accepted and reported.
+func parseMultipart(body []byte, boundary string) *EchoMultipart {
+	multipart := &EchoMultipart{Boundary: boundary, Parts: []EchoPart{}}
+	if boundary == "" {
+		multipart.Error = "missing boundary parameter"
+		return multipart
+	}
+	delimiter := []byte("--" + boundary)
+	start := bytes.Index(body, delimiter)
+	if start < 0 {
+		multipart.Error = "boundary delimiter not found"
+		return multipart
+	}
+	multipart.PreambleSize = start
+	endings := map[string]bool{}
+	pos := start + len(delimiter)
+	for {
+		if bytes.HasPrefix(body[pos:], []byte("--")) {
+			multipart.Closed = true
+			multipart.EpilogueSize = len(body) - pos - 2
+			break
+		}
+		line, next, ending, ok := readBodyLine(body, pos)
+		if !ok {
+			multipart.Error = "truncated delimiter line"
+			break
+		}
+		if strings.TrimRight(line, " \t") != "" {
+			multipart.Error = "unexpected data after the delimiter"
+		}
+		endings[ending] = true
+		pos = next
+		part := EchoPart{HeaderLines: []string{}}
+		for {
+			line, next, ending, ok = readBodyLine(body, pos)
+			if !ok {
+				multipart.Error = "truncated part header"
+				multipart.Parts = append(multipart.Parts, part)
+				return multipart.withLineEnding(endings)
+			}
+			endings[ending] = true
+			pos = next
+			if line == "" {
+				break
+			}
+			part.HeaderLines = append(part.HeaderLines, line)
+			part.addHeader(line)
+		}
+		end := bytes.Index(body[pos:], append([]byte("\n"), delimiter...))
+		if end < 0 {
+			multipart.Error = "closing delimiter not found"
+			part.Size, part.Hash = len(body)-pos, bodyHash(body[pos:])
+			multipart.Parts = append(multipart.Parts, part)
+			break
+		}
+		contentEnd := pos + end
+		if contentEnd > pos && body[contentEnd-1] == '\r' {
+			contentEnd--
+			endings["CRLF"] = true
+		} else {
+			endings["LF"] = true
+		}
+		part.Size, part.Hash = contentEnd-pos, bodyHash(body[pos:contentEnd])
+		multipart.Parts = append(multipart.Parts, part)
+		pos += end + 1 + len(delimiter)
+	}
+	return multipart.withLineEnding(endings)
+}
+
+func (m *EchoMultipart) withLineEnding(endings map[string]bool) *EchoMultipart {
+	switch {
+	case endings["CRLF"] && endings["LF"]:
+		m.LineEnding = "mixed"
+	case endings["LF"]:
+		m.LineEnding = "LF"
+	case endings["CRLF"]:
+		m.LineEnding = "CRLF"
+	}
+	return m
+}
+
+// readBodyLine returns the line starting at the position without its line ending, and the position of the next line.
+func readBodyLine(body []byte, pos int) (string, int, string, bool) {
+	end := bytes.IndexByte(body[pos:], '\n')
+	if end < 0 {
+		return "", pos, "", false
+	}
+	line, ending := string(body[pos:pos+end]), "LF"
+	if strings.HasSuffix(line, "\r") {
+		line, ending = line[:len(line)-1], "CRLF"
+	}
+	return line, pos + end + 1, ending, true
+}
+
+func (p *EchoPart) addHeader(line string) {
+	name, value, err := common.SplitHeaderLine(line)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "content-disposition":
+		if _, params, err := mime.ParseMediaType(value); err == nil {
+			p.Name, p.FileName = params["name"], params["filename"]
+		}
+	case "content-type":
+		p.ContentType = strings.TrimSpace(value)
+	}
+}
+
+func describeJson(body []byte) *EchoJson {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return &EchoJson{Error: fmt.Sprintf("%v (offset %d)", err, syntaxError.Offset)}
+		}
+		return &EchoJson{Error: err.Error()}
+	}
+	description := &EchoJson{Valid: true}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err == nil && pretty.Len() <= maxPrettyJsonSize {
+		description.Pretty = pretty.String()
+	}
+	return description
+}
+
+// describe returns the description of the plain text echo response, the nested lines are indented.
+func (c *EchoBodyContent) describe() string {
+	var b strings.Builder
+	if c.ContentEncoding != "" {
+		b.WriteString("request-body-content-encoding: " + c.ContentEncoding + "\r\n")
+	}
+	if c.Skipped != "" {
+		b.WriteString("request-body-description-skipped: " + c.Skipped + "\r\n")
+	}
+	if c.DecodingError != "" {
+		b.WriteString("request-body-decoding-error: " + c.DecodingError + "\r\n")
+	} else if c.DecodedHash != "" {
+		if c.DecodedTruncated {
+			b.WriteString(fmt.Sprintf("request-body-decoded-size: %d (truncated at the limit)\r\n", c.DecodedSize))
+		} else {
+			b.WriteString(fmt.Sprintf("request-body-decoded-size: %d\r\n", c.DecodedSize))
+		}
+		b.WriteString("request-body-decoded-hash: " + c.DecodedHash + "\r\n")
+	}
+	if c.MediaType != "" {
+		b.WriteString("request-body-media-type: " + c.MediaType + "\r\n")
+	}
+	if m := c.Multipart; m != nil {
+		b.WriteString(fmt.Sprintf("request-body-multipart: boundary %q, %s, preamble %d B, epilogue %d B, closed %t\r\n",
+			m.Boundary, m.LineEnding, m.PreambleSize, m.EpilogueSize, m.Closed))
+		if m.Error != "" {
+			b.WriteString("request-body-multipart-error: " + m.Error + "\r\n")
+		}
+		for i, part := range m.Parts {
+			b.WriteString(fmt.Sprintf("- part %d: size %d, hash %s\r\n", i+1, part.Size, part.Hash))
+			for _, line := range part.HeaderLines {
+				b.WriteString("  " + line + "\r\n")
+			}
+		}
+	}
+	if len(c.Form) > 0 {
+		b.WriteString("request-body-form:\r\n")
+		for _, field := range c.Form {
+			b.WriteString(fmt.Sprintf("- %s=%s (%q = %q)\r\n", field.RawName, field.RawValue, field.Name, field.Value))
+		}
+	}
+	if c.Json != nil {
+		if c.Json.Valid {
+			b.WriteString("request-body-json: valid\r\n")
+		} else {
+			b.WriteString("request-body-json: invalid, " + c.Json.Error + "\r\n")
+		}
+		for _, line := range strings.Split(c.Json.Pretty, "\n") {
+			if line != "" {
+				b.WriteString("  " + line + "\r\n")
+			}
+		}
+	}
+	return b.String()
+}
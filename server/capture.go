@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"rawh/common"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// captureLog appends the received requests to the capture file, the connections write it concurrently.
+type captureLog struct {
+	mu          sync.Mutex
+	file        *os.File
+	maxBodySize int
+}
+
+func openCaptureLog(path string, maxBodySize int) (*captureLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening capture file: %v", err)
+	}
+	return &captureLog{file: file, maxBodySize: maxBodySize}, nil
+}
+
+func (l *captureLog) write(reqData *RequestData) error {
+	capture := common.Capture{
+		Time:           reqData.startTime,
+		ConnectionId:   reqData.connectionId,
+		RemoteAddress:  reqData.remoteAddress,
+		LocalAddress:   reqData.localAddress,
+		RawStartLine:   reqData.rawStartLine,
+		RawHeaderLines: reqData.rawHeaderLines,
+		RawHeaderEnd:   reqData.rawHeaderEnd,
+		BodySize:       reqData.bodySize,
+		BodyHash:       reqData.bodyHash,
+		ReadMs:         reqData.readDuration.Milliseconds(),
+		SleepMs:        reqData.sleepDuration.Milliseconds(),
+		BodyNotRead:    len(reqData.headers.Values("Transfer-Encoding")) > 0,
+	}
+	if capture.RawHeaderLines == nil {
+		capture.RawHeaderLines = []string{}
+	}
+	if head := capture.Head(); !utf8.Valid(head) {
+		capture.RawHead = head
+		capture.RawStartLine, capture.RawHeaderLines, capture.RawHeaderEnd = "", []string{}, ""
+	}
+	if len(reqData.body) <= l.maxBodySize {
+		capture.Body = reqData.body
+	}
+	if reqData.error != nil {
+		capture.Error = reqData.error.Error()
+	}
+	data, err := json.Marshal(capture)
+	if err != nil {
+		return fmt.Errorf("error encoding capture: %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing capture file: %v", err)
+	}
+	return nil
+}
+
+// captured reports whether anything of the request was received, connections closed without a request are not captured.
+func (r *RequestData) captured() bool {
+	return strings.TrimSpace(r.rawStartLine) != ""
+}
+
+func (l *captureLog) close() {
+	common.SafeClose(l.file)
+}
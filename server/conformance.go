@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxConformingLineLength is the longest request or header line, with its terminator, accepted in the strict mode.
+const maxConformingLineLength = 8192
+
+var (
+	errMalformedRequest = errors.New("malformed request")
+	errUriTooLong       = errors.New("request line too long")
+	errHeaderTooLarge   = errors.New("header line too long")
+	errLineTooLong      = errors.New("line too long")
+)
+
+// tokenCharacters are the tchar of RFC 9110 section 5.6.2.
+const tokenCharacters = "!#$%&'*+-.^_`|~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// requestLinePattern is 'method SP request-target SP HTTP-version' (RFC 9112 section 3), the method is a token.
+var requestLinePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+ [^ ]+ HTTP/[0-9]\\.[0-9]$")
+
+// checkConformance looks for the violations of the request head before the body is read,
+// the strict mode rejects the request with them, the lenient one logs them as warnings.
+func (s *Server) checkConformance(reqData *RequestData) {
+	reqData.violations = reqData.conformanceViolations()
+	if len(reqData.violations) == 0 {
+		return
+	}
+	outcome := "warning"
+	if s.options.Strict {
+		reqData.error = fmt.Errorf("%w:\r\n- %s", errMalformedRequest, strings.Join(reqData.violations, "\r\n- "))
+		outcome = "rejected"
+	}
+	for _, violation := range reqData.violations {
+		log.Printf("%s: %s", outcome, violation)
+	}
+}
+
+// readLine reads the raw line with its terminator, a limit above 0 stops reading as soon as the line exceeds it,
+// so an endless line is not kept in memory; the part read up to the limit is returned with errLineTooLong.
+func readLine(reader *bufio.Reader, limit int) (string, error) {
+	if limit <= 0 {
+		return reader.ReadString('\n')
+	}
+	var line []byte
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return string(line), err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			return string(line), nil
+		}
+		if len(line) > limit {
+			return string(line[:limit]), errLineTooLong
+		}
+	}
+}
+
+// conformanceViolations returns the rules of RFC 9110 and RFC 9112 broken by the request head, as a strict origin checks them.
+func (r *RequestData) conformanceViolations() []string {
+	var violations []string
+	violations = append(violations, lineViolations(r.rawStartLine, "request line")...)
+	if line := strings.TrimSuffix(strings.TrimSuffix(r.rawStartLine, "\n"), "\r"); !requestLinePattern.MatchString(line) {
+		violations = append(violations, fmt.Sprintf("RFC 9112 section 3: the request line must be 'method SP request-target SP HTTP-version' "+
+			"with a token method and single spaces, got %s", strconv.Quote(line)))
+	}
+	var hosts, contentLengths, transferEncodings []string
+	for _, rawLine := range r.rawHeaderLines {
+		violations = append(violations, lineViolations(rawLine, "header line")...)
+		line := strings.TrimSuffix(strings.TrimSuffix(rawLine, "\n"), "\r")
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			violations = append(violations, fmt.Sprintf("RFC 9112 section 5.2: the obsolete line folding is not allowed, got %s", strconv.Quote(line)))
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			violations = append(violations, fmt.Sprintf("RFC 9112 section 5: the header line must be 'field-name: field-value', got %s", strconv.Quote(line)))
+			continue
+		}
+		if trimmed := strings.TrimRight(name, " \t"); trimmed != name {
+			violations = append(violations, fmt.Sprintf("RFC 9112 section 5.1: no whitespace is allowed between the field name and the colon, got %s", strconv.Quote(line)))
+			name = trimmed
+		}
+		if invalid := invalidTokenCharacter(name); invalid != "" {
+			violations = append(violations, fmt.Sprintf("RFC 9110 section 5.1: the field name must be a token, %s contains %s", strconv.Quote(name), invalid))
+		}
+		if invalid := invalidFieldValueCharacter(value); invalid != "" {
+			violations = append(violations, fmt.Sprintf("RFC 9110 section 5.5: the field value of %s contains %s", strconv.Quote(name), invalid))
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "host":
+			hosts = append(hosts, value)
+		case "content-length":
+			contentLengths = append(contentLengths, value)
+		case "transfer-encoding":
+			transferEncodings = append(transferEncodings, value)
+		}
+	}
+	if r.rawHeaderEnd != "" {
+		violations = append(violations, lineViolations(r.rawHeaderEnd, "header section end")...)
+	}
+	switch {
+	case len(hosts) == 0 && r.httpVersion == "HTTP/1.1":
+		violations = append(violations, "RFC 9112 section 3.2: an HTTP/1.1 request must have the Host header")
+	case len(hosts) > 1:
+		violations = append(violations, fmt.Sprintf("RFC 9112 section 3.2: the request must not have more than one Host header, got %d", len(hosts)))
+	}
+	violations = append(violations, contentLengthViolations(contentLengths)...)
+	if len(contentLengths) > 0 && len(transferEncodings) > 0 {
+		violations = append(violations, "RFC 9112 section 6.1: the request must not have both Content-Length and Transfer-Encoding")
+	}
+	return violations
+}
+
+// lineViolations checks the line terminator and the length of the raw line.
+func lineViolations(rawLine string, place string) []string {
+	var violations []string
+	content := strings.TrimSuffix(rawLine, "\n")
+	if !strings.HasSuffix(content, "\r") {
+		violations = append(violations, fmt.Sprintf("RFC 9112 section 2.2: the %s must end with CRLF, not a bare LF, got %s", place, strconv.Quote(rawLine)))
+	} else if content = strings.TrimSuffix(content, "\r"); strings.Contains(content, "\r") {
+		violations = append(violations, fmt.Sprintf("RFC 9112 section 2.2: the %s contains a bare CR, got %s", place, strconv.Quote(rawLine)))
+	}
+	if len(rawLine) > maxConformingLineLength {
+		rule := "RFC 9110 section 5.4"
+		if place == "request line" {
+			rule = "RFC 9112 section 3"
+		}
+		violations = append(violations, fmt.Sprintf("%s: the %s of %d bytes exceeds the limit of %d bytes", rule, place, len(rawLine), maxConformingLineLength))
+	}
+	return violations
+}
+
+// contentLengthViolations checks that the Content-Length values are decimal numbers (RFC 9110 section 8.6),
+// the differing values cannot frame the body (RFC 9112 section 6.3).
+func contentLengthViolations(values []string) []string {
+	var violations []string
+	distinct := map[string]bool{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" || strings.Trim(item, "0123456789") != "" {
+				violations = append(violations, fmt.Sprintf("RFC 9110 section 8.6: the Content-Length must be a decimal number, got %s", strconv.Quote(item)))
+				continue
+			}
+			distinct[strings.TrimLeft(item, "0")] = true
+		}
+	}
+	if len(distinct) > 1 {
+		violations = append(violations, fmt.Sprintf("RFC 9112 section 6.3: the Content-Length values differ, got %s", strconv.Quote(strings.Join(values, ", "))))
+	}
+	return violations
+}
+
+// invalidTokenCharacter describes the first character which is not a token character (RFC 9110 section 5.6.2), empty when there is none.
+func invalidTokenCharacter(name string) string {
+	if name == "" {
+		return "no character"
+	}
+	for _, c := range []byte(name) {
+		if c >= 0x80 || !strings.ContainsRune(tokenCharacters, rune(c)) {
+			return fmt.Sprintf("the invalid character %q", c)
+		}
+	}
+	return ""
+}
+
+// invalidFieldValueCharacter describes the first control character other than HTAB, empty when there is none.
+func invalidFieldValueCharacter(value string) string {
+	for _, c := range []byte(value) {
+		if (c < 0x20 && c != '\t') || c == 0x7F {
+			return fmt.Sprintf("the invalid character %q", c)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"rawh/common"
+	"strconv"
+	"strings"
+)
+
+const (
+	EchoFormatText    = "text"
+	EchoFormatJson    = "json"
+	EchoFormatYaml    = "yaml"
+	EchoFormatMessage = "http"
+)
+
+var echoContentTypes = map[string]string{
+	EchoFormatText:    "text/plain",
+	EchoFormatJson:    "application/json",
+	EchoFormatYaml:    "application/yaml",
+	EchoFormatMessage: "message/http",
+}
+
+// acceptedEchoFormats maps the Accept media types to the echo formats.
+var acceptedEchoFormats = map[string]string{
+	"text/plain":         EchoFormatText,
+	"application/json":   EchoFormatJson,
+	"application/yaml":   EchoFormatYaml,
+	"application/x-yaml": EchoFormatYaml,
+	"text/yaml":          EchoFormatYaml,
+	"message/http":       EchoFormatMessage,
+}
+
+// echoFormatNames maps the names accepted by the query parameter and the header to the echo formats.
+var echoFormatNames = map[string]string{
+	"text":         EchoFormatText,
+	"plain":        EchoFormatText,
+	"json":         EchoFormatJson,
+	"yaml":         EchoFormatYaml,
+	"yml":          EchoFormatYaml,
+	"http":         EchoFormatMessage,
+	"message":      EchoFormatMessage,
+	"message/http": EchoFormatMessage,
+}
+
+// echoFormat selects the echo format: the query parameter wins over the Rawh-Format header, which wins over Accept.
+func (r *RequestData) echoFormat() string {
+	if format, ok := echoFormatNames[strings.ToLower(common.ExtractQueryParam(r.requestURI, common.FormatQueryParamName))]; ok {
+		return format
+	}
+	for _, value := range r.headers.Values(common.FormatHeaderName) {
+		if format, ok := echoFormatNames[strings.ToLower(strings.TrimSpace(value))]; ok {
+			return format
+		}
+	}
+	// the accepted format of the highest quality value wins, the first one of equal quality; q=0 refuses the type
+	selected, selectedQuality := EchoFormatText, 0.0
+	for _, value := range r.headers.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			format, ok := acceptedEchoFormats[mediaType]
+			if !ok {
+				continue
+			}
+			quality := 1.0
+			if q, found := params["q"]; found {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					continue
+				}
+			}
+			if quality > selectedQuality {
+				selected, selectedQuality = format, quality
+			}
+		}
+	}
+	return selected
+}
+
+// Echo is the structured description of the received request.
+type Echo struct {
+	StartLine EchoStartLine `json:"startLine"`
+	Target    EchoTarget    `json:"target"`
+	Headers   []EchoHeader  `json:"headers"`
+	Body      EchoBody      `json:"body"`
+	// Violations are the message syntax rules broken by the request, accepted by the lenient server.
+	Violations []string    `json:"violations,omitempty"`
+	Expect     *EchoExpect `json:"expect,omitempty"`
+	// Interim are the status lines of the 1xx responses sent before this one.
+	Interim    []string        `json:"interim,omitempty"`
+	Durations  EchoDurations   `json:"durations"`
+	Connection EchoConnection  `json:"connection"`
+	Forwarding *EchoForwarding `json:"forwarding,omitempty"`
+}
+
+type EchoStartLine struct {
+	Raw           string `json:"raw"`
+	Visible       string `json:"visible"`
+	Hex           string `json:"hex,omitempty"`
+	Method        string `json:"method"`
+	RequestTarget string `json:"requestTarget"`
+	HttpVersion   string `json:"httpVersion"`
+}
+
+// EchoHeader describes a header line, Visible shows its invisible characters and line terminator, Hex is set with the hex dump enabled.
+type EchoHeader struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Raw     string `json:"raw"`
+	Visible string `json:"visible"`
+	Hex     string `json:"hex,omitempty"`
+}
+
+type EchoBody struct {
+	Size int `json:"size"`
+	// Hash is the first of the Hashes, computed by the first selected algorithm.
+	Hash   string   `json:"hash"`
+	Hashes []string `json:"hashes"`
+	// Partial is set when the client stopped sending before the Content-Length was reached.
+	Partial bool             `json:"partial,omitempty"`
+	Content *EchoBodyContent `json:"content,omitempty"`
+}
+
+type EchoDurations struct {
+	ReadMs  int64 `json:"readMs"`
+	SleepMs int64 `json:"sleepMs"`
+}
+
+type EchoConnection struct {
+	Id uint64 `json:"id"`
+	// RequestIndex counts the requests received on the connection from 1.
+	RequestIndex  int      `json:"requestIndex"`
+	RemoteAddress string   `json:"remoteAddress"`
+	LocalAddress  string   `json:"localAddress"`
+	Tls           *EchoTls `json:"tls,omitempty"`
+	// ProxyProtocol is the PROXY protocol header, the remote address is the balancer's and the source address the client's.
+	ProxyProtocol *common.ProxyHeader `json:"proxyProtocol,omitempty"`
+}
+
+func newEcho(reqData *RequestData, display common.Display) *Echo {
+	echo := &Echo{
+		StartLine: EchoStartLine{
+			Raw:           reqData.startLine,
+			Visible:       common.VisibleLine(reqData.rawStartLine),
+			Hex:           displayHex(display, reqData.rawStartLine),
+			Method:        reqData.method,
+			RequestTarget: reqData.requestURI,
+			HttpVersion:   reqData.httpVersion,
+		},
+		Target:  reqData.analyzeTarget(),
+		Headers: []EchoHeader{},
+		Body:    EchoBody{Size: reqData.bodySize, Hash: reqData.bodyHash, Hashes: reqData.bodyHashes, Partial: reqData.bodyPartial},
+		Durations: EchoDurations{
+			ReadMs:  reqData.readDuration.Milliseconds(),
+			SleepMs: reqData.sleepDuration.Milliseconds(),
+		},
+		Connection: EchoConnection{
+			Id:            reqData.connectionId,
+			RequestIndex:  reqData.requestIndex,
+			RemoteAddress: reqData.remoteAddress,
+			LocalAddress:  reqData.localAddress,
+			Tls:           newEchoTls(reqData.tlsState),
+			ProxyProtocol: reqData.proxyHeader,
+		},
+		Violations: reqData.violations,
+		Expect:     reqData.expect,
+		Interim:    reqData.interimSent,
+		Forwarding: reqData.analyzeForwarding(),
+	}
+	if reqData.describeBodyRequested() {
+		echo.Body.Content = reqData.describeBody()
+	}
+	for _, line := range reqData.rawHeaderLines {
+		header := EchoHeader{Raw: strings.TrimRight(line, "\r\n"), Visible: common.VisibleLine(line), Hex: displayHex(display, line)}
+		if name, value, err := common.SplitHeaderLine(header.Raw); err == nil {
+			header.Name, header.Value = strings.TrimSpace(name), strings.TrimSpace(value)
+		}
+		echo.Headers = append(echo.Headers, header)
+	}
+	return echo
+}
+
+func displayHex(display common.Display, line string) string {
+	if !display.HexDump {
+		return ""
+	}
+	return hex.EncodeToString([]byte(line))
+}
+
+// rawMessage returns the request exactly as it was received, like the TRACE method does.
+func (r *RequestData) rawMessage() string {
+	var b strings.Builder
+	b.WriteString(r.rawStartLine)
+	for _, line := range r.rawHeaderLines {
+		b.WriteString(line)
+	}
+	b.WriteString(r.rawHeaderEnd)
+	b.Write(r.body)
+	return b.String()
+}
+
+// PrintEchoResponse sends the request description in the format selected by the client, the body of the HEAD response is omitted.
+func (s *Server) PrintEchoResponse(w io.Writer, reqData *RequestData, keepAlive bool) {
+	format := reqData.echoFormat()
+	content, err := s.echoContent(reqData, format)
+	if err != nil {
+		s.PrintErrorResponse(w, http.StatusInternalServerError, "error encoding echo: "+err.Error())
+		return
+	}
+	s.printResponseHead(w, reqData, echoContentTypes[format], len(content), keepAlive)
+	if reqData.method != "HEAD" {
+		s.respWrite(w, content)
+	}
+}
+
+// echoContent returns the request description in the format.
+func (s *Server) echoContent(reqData *RequestData, format string) (string, error) {
+	switch format {
+	case EchoFormatJson:
+		data, err := json.MarshalIndent(newEcho(reqData, s.options.Display), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	case EchoFormatYaml:
+		return common.MarshalYaml(newEcho(reqData, s.options.Display)), nil
+	case EchoFormatMessage:
+		return reqData.rawMessage(), nil
+	}
+	return s.plainTextEcho(reqData), nil
+}
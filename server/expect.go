@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExpectContinueImmediate = "immediate"
+	ExpectContinueNever     = "never"
+)
+
+var errExpectationRejected = errors.New("expectation rejected")
+
+// expectBehavior is how the server answers 'Expect: 100-continue': it sends '100 Continue' after the delay,
+// never sends it and waits for the body anyway, or rejects the request with the final 4xx status.
+type expectBehavior struct {
+	never        bool
+	delay        time.Duration
+	rejectStatus int
+}
+
+// parseExpectBehavior parses 'immediate', 'never', a delay like '2s' or a 4xx status like '417'.
+func parseExpectBehavior(spec string) (expectBehavior, error) {
+	switch spec {
+	case ExpectContinueImmediate, "":
+		return expectBehavior{}, nil
+	case ExpectContinueNever:
+		return expectBehavior{never: true}, nil
+	}
+	if status, err := strconv.Atoi(spec); err == nil {
+		if status < 400 || status > 499 {
+			return expectBehavior{}, fmt.Errorf("invalid 100-continue rejection status %d, expected 4xx", status)
+		}
+		return expectBehavior{rejectStatus: status}, nil
+	}
+	delay, err := time.ParseDuration(spec)
+	if err != nil || delay < 0 {
+		return expectBehavior{}, fmt.Errorf("invalid 100-continue behavior '%s', expected 'immediate', 'never', a delay like '2s' or a 4xx status", spec)
+	}
+	return expectBehavior{delay: delay}, nil
+}
+
+// EchoExpect describes how the server answered the Expect header.
+type EchoExpect struct {
+	Value string `json:"value"`
+	// Behavior is the applied one, the server option or the request override.
+	Behavior string `json:"behavior"`
+	// Response is the sent interim or final response, empty when none was sent.
+	Response string `json:"response,omitempty"`
+	DelayMs  int64  `json:"delayMs"`
+	// rejectStatus is the final status of the rejected expectation
+	rejectStatus int
+}
+
+// describe returns the one line description of the plain text echo response.
+func (e *EchoExpect) describe() string {
+	if e.Response == "" {
+		return fmt.Sprintf("%s, behavior %s, no response sent", e.Value, e.Behavior)
+	}
+	return fmt.Sprintf("%s, behavior %s, '%s' sent after %dms", e.Value, e.Behavior, e.Response, e.DelayMs)
+}
+
+// expectSpec returns the behavior requested by the rawh-expect-continue query parameter or header, or the server option.
+func (s *Server) expectSpec(reqData *RequestData) string {
+	if spec := reqData.override(common.ExpectContinueQueryParamName, common.ExpectContinueHeaderName); spec != "" {
+		return spec
+	}
+	if s.options.ExpectContinue == "" {
+		return ExpectContinueImmediate
+	}
+	return s.options.ExpectContinue
+}
+
+// handleExpect answers the Expect header before the body is read (RFC 9110 section 10.1.1), an unknown expectation is rejected with 417
+// and an invalid behavior override with 400.
+// The interim response is not sent to HTTP/1.0 clients, which do not understand it.
+func (s *Server) handleExpect(conn net.Conn, reqData *RequestData) {
+	values := reqData.headers.Values("Expect")
+	if len(values) == 0 {
+		return
+	}
+	spec := s.expectSpec(reqData)
+	reqData.expect = &EchoExpect{Value: strings.Join(values, ", "), Behavior: spec}
+	behavior, err := parseExpectBehavior(spec)
+	if err != nil {
+		// the invalid override of the request is answered with 400 naming it, instead of a silent default
+		reqData.expect.rejectStatus = http.StatusBadRequest
+		reqData.expect.Response = fmt.Sprintf("%d %s", http.StatusBadRequest, http.StatusText(http.StatusBadRequest))
+		reqData.error = fmt.Errorf("%w: %v", errExpectationRejected, err)
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(reqData.expect.Value), "100-continue") {
+		behavior = expectBehavior{rejectStatus: http.StatusExpectationFailed}
+		reqData.expect.Behavior = "unknown expectation"
+	}
+	if behavior.rejectStatus > 0 {
+		reqData.expect.rejectStatus = behavior.rejectStatus
+		reqData.expect.Response = fmt.Sprintf("%d %s", behavior.rejectStatus, http.StatusText(behavior.rejectStatus))
+		reqData.error = fmt.Errorf("%w: '%s' answered with %s", errExpectationRejected, reqData.expect.Value, reqData.expect.Response)
+		return
+	}
+	if behavior.never || reqData.httpVersion == "HTTP/1.0" || reqData.contentLength == 0 {
+		s.logVerbose("Expect: no 100 Continue sent, waiting for the body")
+		return
+	}
+	if behavior.delay > 0 {
+		s.logVerbose(fmt.Sprintf("Expect: sending 100 Continue in %s", behavior.delay))
+		time.Sleep(behavior.delay)
+	}
+	reqData.expect.Response = "100 Continue"
+	reqData.expect.DelayMs = behavior.delay.Milliseconds()
+	s.respPrintln(conn, "HTTP/1.1 100 Continue")
+	s.respPrintln(conn, "")
+}
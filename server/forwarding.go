@@ -0,0 +1,281 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// EchoForwarding is the forwarding chain claimed by the proxies, and by the client, which can send any of these headers itself.
+type EchoForwarding struct {
+	Forwarded       []EchoForwardedElement `json:"forwarded,omitempty"`
+	XForwardedFor   []string               `json:"xForwardedFor,omitempty"`
+	XForwardedProto []string               `json:"xForwardedProto,omitempty"`
+	XForwardedHost  []string               `json:"xForwardedHost,omitempty"`
+	XForwardedPort  []string               `json:"xForwardedPort,omitempty"`
+	XRealIp         []string               `json:"xRealIp,omitempty"`
+	Via             []EchoVia              `json:"via,omitempty"`
+	// Chain is the client and the proxies in the order of the hops, it ends with the remote address of the connection.
+	Chain []string `json:"chain"`
+	// Proto and Host are the last claimed ones, which the closest proxy is supposed to set.
+	Proto string   `json:"proto,omitempty"`
+	Host  string   `json:"host,omitempty"`
+	Flags []string `json:"flags"`
+}
+
+// EchoForwardedElement is a Forwarded header element (RFC 7239 section 4), the values are unquoted.
+type EchoForwardedElement struct {
+	Raw   string `json:"raw"`
+	For   string `json:"for,omitempty"`
+	By    string `json:"by,omitempty"`
+	Host  string `json:"host,omitempty"`
+	Proto string `json:"proto,omitempty"`
+}
+
+// EchoVia is a Via header entry (RFC 9110 section 7.6.3).
+type EchoVia struct {
+	Raw        string `json:"raw"`
+	Protocol   string `json:"protocol"`
+	ReceivedBy string `json:"receivedBy"`
+	Comment    string `json:"comment,omitempty"`
+}
+
+// analyzeForwarding parses the forwarding headers and flags their inconsistencies, nil is returned when there are none.
+func (r *RequestData) analyzeForwarding() *EchoForwarding {
+	f := &EchoForwarding{
+		XForwardedFor:   r.headerList("X-Forwarded-For"),
+		XForwardedProto: r.headerList("X-Forwarded-Proto"),
+		XForwardedHost:  r.headerList("X-Forwarded-Host"),
+		XForwardedPort:  r.headerList("X-Forwarded-Port"),
+		XRealIp:         r.headerList("X-Real-IP"),
+		Chain:           []string{},
+		Flags:           []string{},
+	}
+	for _, value := range r.headers.Values("Forwarded") {
+		elements, ok := parseForwarded(value)
+		if !ok {
+			f.addFlag("forwarded-syntax-error")
+		}
+		f.Forwarded = append(f.Forwarded, elements...)
+	}
+	for _, entry := range r.headerList("Via") {
+		f.Via = append(f.Via, parseVia(entry))
+	}
+	if len(f.Forwarded) == 0 && len(f.XForwardedFor) == 0 && len(f.XForwardedProto) == 0 && len(f.XForwardedHost) == 0 &&
+		len(f.XForwardedPort) == 0 && len(f.XRealIp) == 0 && len(f.Via) == 0 {
+		return nil
+	}
+	var forwardedFor []string
+	for _, element := range f.Forwarded {
+		if element.For != "" {
+			forwardedFor = append(forwardedFor, element.For)
+		}
+		if element.Proto != "" {
+			f.Proto = element.Proto
+		}
+		if element.Host != "" {
+			f.Host = element.Host
+		}
+	}
+	f.Chain = append(f.Chain, f.XForwardedFor...)
+	if len(f.XForwardedFor) == 0 {
+		f.Chain = append(f.Chain, forwardedFor...)
+	}
+	if r.proxyHeader != nil && r.proxyHeader.SourceAddress != "" {
+		f.Chain = append(f.Chain, r.proxyHeader.SourceAddress)
+	}
+	f.Chain = append(f.Chain, r.remoteAddress)
+	if len(f.XForwardedProto) > 0 {
+		f.Proto = f.XForwardedProto[len(f.XForwardedProto)-1]
+	}
+	if len(f.XForwardedHost) > 0 {
+		f.Host = f.XForwardedHost[len(f.XForwardedHost)-1]
+	}
+	f.analyze(r, forwardedFor)
+	return f
+}
+
+func (f *EchoForwarding) analyze(r *RequestData, forwardedFor []string) {
+	for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Port", "X-Real-IP"} {
+		if len(r.headers.Values(name)) > 1 {
+			f.addFlag("multiple-" + strings.ToLower(name) + "-headers")
+		}
+	}
+	if len(f.XForwardedProto) > 1 {
+		f.addFlag("multiple-x-forwarded-proto-values")
+	}
+	for _, entry := range append(append([]string{}, f.XForwardedFor...), f.XRealIp...) {
+		if forwardedNodeIp(entry) == "" {
+			f.addFlag("invalid-forwarded-address")
+		}
+	}
+	for _, node := range forwardedFor {
+		if forwardedNodeIp(node) == "" && node != "unknown" && !strings.HasPrefix(node, "_") {
+			f.addFlag("invalid-forwarded-address")
+		}
+	}
+	// the last hop is TLS when the server terminates it or the PROXY v2 header tells the balancer received TLS
+	tlsHop := r.tlsState != nil || (r.proxyHeader != nil && r.proxyHeader.TlsClient())
+	switch proto := strings.ToLower(f.Proto); {
+	case proto == "https" && !tlsHop:
+		f.addFlag("proto-https-over-plain-hop")
+	case proto == "http" && tlsHop:
+		f.addFlag("proto-http-over-tls-hop")
+	}
+	if len(f.XForwardedPort) > 0 {
+		port := f.XForwardedPort[len(f.XForwardedPort)-1]
+		if (strings.EqualFold(f.Proto, "https") && port == "80") || (strings.EqualFold(f.Proto, "http") && port == "443") {
+			f.addFlag("proto-port-mismatch")
+		}
+	}
+	if len(forwardedFor) > 0 && len(f.XForwardedFor) > 0 && !sameAddresses(forwardedFor, f.XForwardedFor) {
+		f.addFlag("forwarded-and-x-forwarded-for-differ")
+	}
+	if len(f.XRealIp) > 0 && len(f.XForwardedFor) > 0 && forwardedNodeIp(f.XRealIp[0]) != forwardedNodeIp(f.XForwardedFor[0]) {
+		f.addFlag("x-real-ip-differs")
+	}
+	// every proxy adds one Via entry and one forwarded address, the extra leftmost addresses come from the client or from a proxy which adds no Via
+	if len(f.Via) > 0 && len(f.XForwardedFor) > len(f.Via) {
+		f.addFlag("possibly-client-supplied-entries")
+	}
+	if host := r.headers.Values("Host"); f.Host != "" && len(host) > 0 && !strings.EqualFold(f.Host, host[0]) {
+		f.addFlag("forwarded-host-differs-from-host")
+	}
+}
+
+func (f *EchoForwarding) addFlag(flag string) {
+	for _, existing := range f.Flags {
+		if existing == flag {
+			return
+		}
+	}
+	f.Flags = append(f.Flags, flag)
+}
+
+// headerList returns the comma-separated values of all the header lines with the name, in order.
+func (r *RequestData) headerList(name string) []string {
+	var list []string
+	for _, value := range r.headers.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseForwarded parses the Forwarded header value, false is returned when its syntax is broken.
+func parseForwarded(value string) ([]EchoForwardedElement, bool) {
+	var elements []EchoForwardedElement
+	ok := true
+	for _, raw := range splitQuoted(value, ',') {
+		element := EchoForwardedElement{Raw: strings.TrimSpace(raw)}
+		for _, pair := range splitQuoted(raw, ';') {
+			name, pairValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found || name == "" {
+				ok = ok && strings.TrimSpace(pair) == ""
+				continue
+			}
+			pairValue = strings.TrimSpace(pairValue)
+			if strings.HasPrefix(pairValue, `"`) {
+				if len(pairValue) < 2 || !strings.HasSuffix(pairValue, `"`) {
+					ok = false
+				}
+				pairValue = strings.ReplaceAll(strings.Trim(pairValue, `"`), `\"`, `"`)
+			}
+			switch strings.ToLower(name) {
+			case "for":
+				element.For = pairValue
+			case "by":
+				element.By = pairValue
+			case "host":
+				element.Host = pairValue
+			case "proto":
+				element.Proto = pairValue
+			}
+		}
+		elements = append(elements, element)
+	}
+	return elements, ok
+}
+
+// splitQuoted splits the value at the separator outside the quoted strings.
+func splitQuoted(value string, separator byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quoted:
+			i++
+		case value[i] == '"':
+			quoted = !quoted
+		case value[i] == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func parseVia(entry string) EchoVia {
+	via := EchoVia{Raw: entry}
+	rest := entry
+	if i := strings.Index(rest, "("); i >= 0 {
+		via.Comment = strings.TrimSpace(rest[i:])
+		rest = rest[:i]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) > 0 {
+		via.Protocol = fields[0]
+	}
+	if len(fields) > 1 {
+		via.ReceivedBy = fields[1]
+	}
+	return via
+}
+
+// forwardedNodeIp returns the IP address of the node, which may be bracketed and have a port, or empty when it is not an address.
+func forwardedNodeIp(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().String()
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(node, "[]")); err == nil {
+		return addr.String()
+	}
+	return ""
+}
+
+func sameAddresses(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if forwardedNodeIp(a[i]) != forwardedNodeIp(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// describe returns the analysis lines of the plain text echo response.
+func (f *EchoForwarding) describe() []string {
+	lines := []string{"forwarding-chain: " + strings.Join(f.Chain, " -> ")}
+	if f.Proto != "" {
+		lines = append(lines, "forwarding-proto: "+f.Proto)
+	}
+	if f.Host != "" {
+		lines = append(lines, "forwarding-host: "+f.Host)
+	}
+	for _, via := range f.Via {
+		lines = append(lines, "forwarding-via: "+via.Raw)
+	}
+	if len(f.Flags) > 0 {
+		lines = append(lines, "forwarding-flags: "+strings.Join(f.Flags, ", "))
+	}
+	return lines
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rawh/common"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultInspectPrefix = "/_rawh"
+
+// inspectPage is the web UI listing the requests live, it uses no external assets.
+//
+//go:embed ui/index.html
+var inspectPage string
+
+// inspectKeepAlive is the interval of the comments which keep the idle event stream open and detect the closed ones.
+const inspectKeepAlive = 15 * time.Second
+
+// InspectedRequest is a received request kept by the inspection API.
+type InspectedRequest struct {
+	Id           uint64    `json:"id"`
+	Time         time.Time `json:"time"`
+	ConnectionId uint64    `json:"connectionId"`
+	// RawHead is the request line and the header section with the line terminators as they were received.
+	RawHead string `json:"rawHead"`
+	Request *Echo  `json:"request"`
+}
+
+// inspectEvent is sent to the event stream subscribers, the request is nil for the 'clear' event.
+type inspectEvent struct {
+	name    string
+	request *InspectedRequest
+}
+
+// InspectStats are counted since the server start, including the requests which left the buffer.
+type InspectStats struct {
+	StartTime   time.Time         `json:"startTime"`
+	Uptime      string            `json:"uptime"`
+	Connections uint64            `json:"connections"`
+	Requests    uint64            `json:"requests"`
+	BodyBytes   int64             `json:"bodyBytes"`
+	Methods     map[string]uint64 `json:"methods"`
+	Buffered    int               `json:"buffered"`
+	BufferSize  int               `json:"bufferSize"`
+}
+
+// requestRing keeps the last received requests, the oldest ones are dropped when it is full.
+type requestRing struct {
+	mu          sync.Mutex
+	size        int
+	requests    []InspectedRequest
+	lastId      uint64
+	startTime   time.Time
+	bodyBytes   int64
+	methods     map[string]uint64
+	subscribers map[chan inspectEvent]struct{}
+}
+
+func newRequestRing(size int) *requestRing {
+	return &requestRing{size: size, startTime: time.Now(), methods: map[string]uint64{}, subscribers: map[chan inspectEvent]struct{}{}}
+}
+
+// subscribe returns the buffered requests and the channel of the following events, unsubscribe releases the channel.
+func (r *requestRing) subscribe() ([]InspectedRequest, chan inspectEvent, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make(chan inspectEvent, 64)
+	r.subscribers[events] = struct{}{}
+	unsubscribe := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subscribers, events)
+	}
+	return append([]InspectedRequest{}, r.requests...), events, unsubscribe
+}
+
+// publish sends the event to the subscribers, a subscriber which does not keep up misses it.
+func (r *requestRing) publish(event inspectEvent) {
+	for events := range r.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func (r *requestRing) add(reqData *RequestData, echo *Echo) InspectedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastId++
+	inspected := InspectedRequest{
+		Id:           r.lastId,
+		Time:         reqData.startTime,
+		ConnectionId: reqData.connectionId,
+		RawHead:      reqData.rawStartLine + strings.Join(reqData.rawHeaderLines, "") + reqData.rawHeaderEnd,
+		Request:      echo,
+	}
+	r.requests = append(r.requests, inspected)
+	r.publish(inspectEvent{name: "request", request: &inspected})
+	if len(r.requests) > r.size {
+		r.requests = r.requests[len(r.requests)-r.size:]
+	}
+	r.bodyBytes += int64(reqData.bodySize)
+	r.methods[reqData.method]++
+	return inspected
+}
+
+func (r *requestRing) list() []InspectedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]InspectedRequest{}, r.requests...)
+}
+
+func (r *requestRing) get(id uint64) (InspectedRequest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, inspected := range r.requests {
+		if inspected.Id == id {
+			return inspected, true
+		}
+	}
+	return InspectedRequest{}, false
+}
+
+func (r *requestRing) clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = nil
+	r.publish(inspectEvent{name: "clear"})
+}
+
+func (r *requestRing) stats(connections uint64) InspectStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := InspectStats{
+		StartTime:   r.startTime,
+		Uptime:      time.Since(r.startTime).Round(time.Second).String(),
+		Connections: connections,
+		Requests:    r.lastId,
+		BodyBytes:   r.bodyBytes,
+		Methods:     map[string]uint64{},
+		Buffered:    len(r.requests),
+		BufferSize:  r.size,
+	}
+	for method, count := range r.methods {
+		stats.Methods[method] = count
+	}
+	return stats
+}
+
+// inspectPath returns the path of the request below the inspection prefix, the request is not an inspection one when false.
+func (s *Server) inspectPath(reqData *RequestData) (string, bool) {
+	if s.inspect == nil {
+		return "", false
+	}
+	target, err := url.Parse(reqData.requestURI)
+	if err != nil {
+		return "", false
+	}
+	prefix := strings.TrimSuffix(s.options.InspectPrefix, "/")
+	if target.Path != prefix && !strings.HasPrefix(target.Path, prefix+"/") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(target.Path, prefix), "/"), true
+}
+
+// serveInspection responds to the requests of the inspection API, the format is selected as for the echo response;
+// the connection is closed after the response.
+func (s *Server) serveInspection(w io.Writer, reqData *RequestData, path string) {
+	format := reqData.echoFormat()
+	switch {
+	case path == "" && reqData.method == "GET":
+		page := strings.ReplaceAll(inspectPage, "{{prefix}}", strings.TrimSuffix(s.options.InspectPrefix, "/"))
+		s.respPrintln(w, "HTTP/1.1 200 OK")
+		s.respPrintln(w, "Content-Type: text/html; charset=utf-8")
+		s.respPrintln(w, fmt.Sprintf("Content-Length: %d", len(page)))
+		s.respPrintln(w, "Cache-Control: no-store")
+		s.respPrintln(w, "Connection: close")
+		s.respPrintln(w, "")
+		_, _ = io.WriteString(w, page) // not shown in the verbose output
+	case path == "/events" && reqData.method == "GET":
+		s.streamInspection(w)
+	case path == "/requests" && reqData.method == "GET":
+		requests := s.inspect.list()
+		var b strings.Builder
+		for _, inspected := range requests {
+			b.WriteString(fmt.Sprintf("%d %s %s %s (%d B)\n", inspected.Id, inspected.Time.Format(time.RFC3339Nano),
+				inspected.Request.Connection.RemoteAddress, inspected.Request.StartLine.Raw, inspected.Request.Body.Size))
+		}
+		s.printInspectResponse(w, format, requests, b.String())
+	case path == "/requests" && reqData.method == "DELETE":
+		s.inspect.clear()
+		s.printInspectResponse(w, format, map[string]string{"status": "cleared"}, "cleared\n")
+	case strings.HasPrefix(path, "/requests/") && reqData.method == "GET":
+		id, err := strconv.ParseUint(strings.TrimPrefix(path, "/requests/"), 10, 64)
+		if err != nil {
+			s.PrintErrorResponse(w, http.StatusBadRequest, "invalid request id: "+strings.TrimPrefix(path, "/requests/"))
+			return
+		}
+		inspected, ok := s.inspect.get(id)
+		if !ok {
+			s.PrintErrorResponse(w, http.StatusNotFound, fmt.Sprintf("request %d is not in the buffer", id))
+			return
+		}
+		s.printInspectResponse(w, format, inspected, inspectedText(inspected))
+	case path == "/stats" && reqData.method == "GET":
+		stats := s.inspect.stats(s.connections.Load())
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("start-time: %s\nuptime: %s\nconnections: %d\nrequests: %d\nbody-bytes: %d\nbuffered: %d/%d\n",
+			stats.StartTime.Format(time.RFC3339), stats.Uptime, stats.Connections, stats.Requests, stats.BodyBytes, stats.Buffered, stats.BufferSize))
+		methods := make([]string, 0, len(stats.Methods))
+		for method := range stats.Methods {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			b.WriteString(fmt.Sprintf("method-%s: %d\n", method, stats.Methods[method]))
+		}
+		s.printInspectResponse(w, format, stats, b.String())
+	default:
+		s.PrintErrorResponse(w, http.StatusNotFound, fmt.Sprintf("unknown inspection resource: %s %s%s", reqData.method, s.options.InspectPrefix, path))
+	}
+}
+
+// streamInspection sends the buffered and then the new requests as Server-Sent Events until the client disconnects.
+func (s *Server) streamInspection(w io.Writer) {
+	requests, events, unsubscribe := s.inspect.subscribe()
+	defer unsubscribe()
+	s.respPrintln(w, "HTTP/1.1 200 OK")
+	s.respPrintln(w, "Content-Type: text/event-stream")
+	s.respPrintln(w, "Cache-Control: no-store")
+	s.respPrintln(w, "Connection: close")
+	s.respPrintln(w, "")
+	for i := range requests {
+		if err := writeInspectEvent(w, inspectEvent{name: "request", request: &requests[i]}); err != nil {
+			return
+		}
+	}
+	keepAlive := time.NewTicker(inspectKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case event := <-events:
+			err = writeInspectEvent(w, event)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			s.logVerbose(fmt.Sprintf("Event stream closed: %v", err))
+			return
+		}
+	}
+}
+
+func writeInspectEvent(w io.Writer, event inspectEvent) error {
+	data := []byte("{}")
+	id := ""
+	if event.request != nil {
+		var err error
+		if data, err = json.Marshal(event.request); err != nil {
+			return err
+		}
+		id = fmt.Sprintf("id: %d\n", event.request.Id)
+	}
+	_, err := fmt.Fprintf(w, "event: %s\n%sdata: %s\n\n", event.name, id, data)
+	return err
+}
+
+// inspectedText describes the request in the plain text echo layout.
+func inspectedText(inspected InspectedRequest) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("id: %d\ntime: %s\nconnection-id: %d\n", inspected.Id, inspected.Time.Format(time.RFC3339Nano), inspected.ConnectionId))
+	b.WriteString(fmt.Sprintf("remote-address: %s\n", inspected.Request.Connection.RemoteAddress))
+	b.WriteString("request-start-line: " + inspected.Request.StartLine.Raw + "\n")
+	b.WriteString("request-header-lines:\n")
+	for _, header := range inspected.Request.Headers {
+		b.WriteString("- " + header.Raw + "\n")
+	}
+	b.WriteString(fmt.Sprintf("request-body-size: %d\n", inspected.Request.Body.Size))
+	b.WriteString("request-body-hash: " + inspected.Request.Body.Hash + "\n")
+	b.WriteString(fmt.Sprintf("request-read-duration: %dms\n", inspected.Request.Durations.ReadMs))
+	return b.String()
+}
+
+// printInspectResponse sends the value as JSON or YAML, or its text description, with the Content-Length set.
+func (s *Server) printInspectResponse(w io.Writer, format string, value any, text string) {
+	content := text
+	switch format {
+	case EchoFormatJson:
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			s.PrintErrorResponse(w, http.StatusInternalServerError, "error encoding response: "+err.Error())
+			return
+		}
+		content = string(data) + "\n"
+	case EchoFormatYaml:
+		content = common.MarshalYaml(value)
+	default:
+		format = EchoFormatText
+	}
+	s.respPrintln(w, "HTTP/1.1 200 OK")
+	s.respPrintln(w, "Content-Type: "+echoContentTypes[format])
+	s.respPrintln(w, fmt.Sprintf("Content-Length: %d", len(content)))
+	s.respPrintln(w, "Cache-Control: no-store")
+	s.respPrintln(w, "Connection: close")
+	s.respPrintln(w, "")
+	s.respWrite(w, content)
+}
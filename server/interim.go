@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// interimResponse is a 1xx response sent before the final one, its header lines are sent as they are.
+type interimResponse struct {
+	statusLine  string
+	headerLines []string
+}
+
+// parseInterimResponse parses the '<status>[ <reason>][|<header line>]...' specification,
+// e.g. '103 Early Hints|Link: </style.css>; rel=preload; as=style'.
+func parseInterimResponse(spec string) (interimResponse, error) {
+	parts := strings.Split(spec, "|")
+	status, reason, _ := strings.Cut(strings.TrimSpace(parts[0]), " ")
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 199 || code == http.StatusSwitchingProtocols {
+		return interimResponse{}, fmt.Errorf("invalid interim response status '%s', expected 1xx other than 101", status)
+	}
+	if reason == "" {
+		reason = http.StatusText(code)
+	}
+	interim := interimResponse{statusLine: strings.TrimSpace(fmt.Sprintf("HTTP/1.1 %d %s", code, reason))}
+	for _, line := range parts[1:] {
+		if _, _, err := common.SplitHeaderLine(line); err != nil {
+			return interimResponse{}, fmt.Errorf("invalid interim response header '%s': %v", line, err)
+		}
+		interim.headerLines = append(interim.headerLines, line)
+	}
+	return interim, nil
+}
+
+// interimSpecs returns the interim responses requested by the rawh-interim query parameters or headers, or the server option.
+func (s *Server) interimSpecs(reqData *RequestData) []string {
+	var specs []string
+	if target, err := url.Parse(reqData.requestURI); err == nil {
+		specs = target.Query()[common.InterimQueryParamName]
+	}
+	specs = append(specs, reqData.headers.Values(common.InterimHeaderName)...)
+	if len(specs) == 0 {
+		return s.options.InterimResponses
+	}
+	return specs
+}
+
+// sendInterimResponses sends the 1xx responses before the final one, HTTP/1.0 clients do not get them (RFC 9110 section 15.2).
+func (s *Server) sendInterimResponses(conn net.Conn, reqData *RequestData) {
+	specs := s.interimSpecs(reqData)
+	if len(specs) == 0 || reqData.httpVersion == "HTTP/1.0" {
+		return
+	}
+	for i, spec := range specs {
+		interim, err := parseInterimResponse(spec)
+		if err != nil {
+			s.logVerbose(fmt.Sprintf("Interim response skipped: %v", err))
+			continue
+		}
+		if i > 0 && s.options.InterimDelay > 0 {
+			time.Sleep(s.options.InterimDelay)
+		}
+		s.respPrintln(conn, interim.statusLine)
+		for _, line := range interim.headerLines {
+			s.respPrintln(conn, line)
+		}
+		s.respPrintln(conn, "")
+		reqData.interimSent = append(reqData.interimSent, interim.statusLine)
+	}
+	if len(reqData.interimSent) > 0 && s.options.InterimDelay > 0 {
+		time.Sleep(s.options.InterimDelay)
+	}
+}
+
+// validateInterimResponses checks the interim response specifications of the server option.
+func validateInterimResponses(specs []string) error {
+	for _, spec := range specs {
+		if _, err := parseInterimResponse(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,37 +2,123 @@ package server
 
 import (
 	"bufio"
-	"crypto/md5"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"rawh/common"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// maxBufferedBodySize is the body size kept in memory for the echo when the capture allows less, larger bodies are only hashed.
+const maxBufferedBodySize = 1024 * 1024
+
+var errBodyTooLarge = errors.New("request body too large")
+
 type Server struct {
-	port    int
-	verbose bool
+	port        int
+	verbose     bool
+	options     Options
+	capture     *captureLog
+	inspect     *requestRing
+	connections atomic.Uint64
+	tlsConfig   *tls.Config
 }
 
-func NewServer(port int, verbose bool) (s *Server) {
-	return &Server{port: port, verbose: verbose}
+// Options are the server behaviour settings beyond the listening port.
+type Options struct {
+	ReadHeaderTimeout time.Duration
+	ReadBodyTimeout   time.Duration
+	// CaptureFile receives every request as a JSON line when set.
+	CaptureFile string
+	// CaptureMaxBodySize limits the bodies kept in the capture file, the larger ones are described by the size and hash only.
+	CaptureMaxBodySize int
+	// Inspect enables the inspection API at the InspectPrefix paths, which keeps the last InspectBufferSize requests.
+	Inspect           bool
+	InspectPrefix     string
+	InspectBufferSize int
+	// MaxBodySize rejects the requests with a larger Content-Length with 413, 0 disables the limit.
+	MaxBodySize int
+	// BodyHashes are the algorithms hashing the request body, validated by common.ParseHashAlgorithms.
+	BodyHashes []string
+	// ProxyProtocol is 'off', 'optional' or 'required', it reads the PROXY protocol header of the ProxyProtocolVersion
+	// ('auto', '1' or '2') sent by a load balancer before the request.
+	ProxyProtocol        string
+	ProxyProtocolVersion string
+	// ExpectContinue answers 'Expect: 100-continue': 'immediate', 'never', a delay like '2s' or a 4xx status like '417',
+	// the request can override it with the rawh-expect-continue query parameter or header.
+	ExpectContinue string
+	// InterimResponses are the 1xx responses sent before the final one, like '103 Early Hints|Link: </style.css>; rel=preload',
+	// separated by InterimDelay, the request can override them with the rawh-interim query parameters or headers.
+	InterimResponses []string
+	InterimDelay     time.Duration
+	// TlsCertFile and TlsKeyFile make the server accept TLS connections, after the PROXY protocol header when it is enabled.
+	TlsCertFile string
+	TlsKeyFile  string
+	// SseInterval and SseCount shape the event stream sent to the requests accepting text/event-stream, 0 events stream them
+	// until the client disconnects; the request can override them with the rawh-sse query parameters or headers.
+	SseInterval time.Duration
+	SseCount    int
+	// Strict rejects the requests breaking the message syntax rules of RFC 9110 and RFC 9112 with 400, explaining the broken rules,
+	// otherwise they are accepted and the violations are logged as warnings.
+	Strict bool
+	// KeepAlive serves the next requests of the persistent connections, IdleTimeout limits the wait for them, 0 disables the limit.
+	KeepAlive   bool
+	IdleTimeout time.Duration
+	// Display controls how the raw lines are shown in the verbose output and the echo response.
+	Display common.Display
+}
+
+func NewServer(port int, verbose bool, options Options) (s *Server) {
+	s = &Server{port: port, verbose: verbose, options: options}
+	if s.options.ProxyProtocol == "" {
+		s.options.ProxyProtocol = common.ProxyProtocolOff
+	}
+	if s.options.ProxyProtocolVersion == "" {
+		s.options.ProxyProtocolVersion = "auto"
+	}
+	if options.Inspect {
+		if s.options.InspectPrefix == "" {
+			s.options.InspectPrefix = DefaultInspectPrefix
+		}
+		s.inspect = newRequestRing(max(options.InspectBufferSize, 1))
+	}
+	return s
 }
 
 type RequestData struct {
-	startLine     string
-	method        string
-	requestURI    string
-	httpVersion   string
-	headers       *common.HttpHeaders
-	bodySize      int
-	bodyHash      string
-	readDuration  time.Duration
-	sleepDuration time.Duration
-	error         error
-	contentLength int
+	startLine      string
+	method         string
+	requestURI     string
+	httpVersion    string
+	headers        *common.HttpHeaders
+	bodySize       int
+	bodyHash       string
+	bodyHashes     []string
+	bodyPartial    bool
+	readDuration   time.Duration
+	sleepDuration  time.Duration
+	error          error
+	contentLength  int
+	rawStartLine   string
+	rawHeaderLines []string
+	rawHeaderEnd   string
+	body           []byte
+	remoteAddress  string
+	localAddress   string
+	startTime      time.Time
+	connectionId   uint64
+	requestIndex   int
+	proxyHeader    *common.ProxyHeader
+	tlsState       *tls.ConnectionState
+	expect         *EchoExpect
+	interimSent    []string
+	violations     []string
 }
 
 func NewRequestData(normalizeHeaders bool) *RequestData {
@@ -41,87 +127,254 @@ func NewRequestData(normalizeHeaders bool) *RequestData {
 		sleepDuration: time.Duration(0),
 		contentLength: 0,
 		bodyHash:      "empty",
+		bodyHashes:    []string{"empty"},
 	}
 }
 
 func (r *RequestData) setStartLine(line string) {
 	r.startLine = line
-	parts := strings.Split(r.startLine, " ")
-	if len(parts) == 3 {
-		r.method, r.requestURI, r.httpVersion = parts[0], parts[1], parts[2]
+	// the method is the first word and the version the last one, so extra whitespace does not break the parsing
+	parts := strings.Fields(r.startLine)
+	if len(parts) >= 3 {
+		r.method, r.requestURI, r.httpVersion = parts[0], strings.Join(parts[1:len(parts)-1], " "), parts[len(parts)-1]
 		r.sleepDuration = common.ExtractSleepDurationFromQuery(r.requestURI)
 	}
 }
+
+// override returns the value of the query parameter, or of the header when there is no parameter, trimmed.
+func (r *RequestData) override(queryParamName string, headerName string) string {
+	if value := common.ExtractQueryParam(r.requestURI, queryParamName); value != "" {
+		return value
+	}
+	if values := r.headers.Values(headerName); len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
 func (s *Server) logVerbose(line string) {
 	line = strings.TrimSpace(line)
 	if s.verbose {
 		log.Printf("# %s\n", line)
 	}
 }
+
+// reqVerbose shows the raw received line, trimmed unless the invisible characters are shown.
 func (s *Server) reqVerbose(line string) {
-	line = strings.TrimSpace(line)
+	s.lineVerbose("<", line)
+}
+
+func (s *Server) lineVerbose(direction string, line string) {
 	if s.verbose {
-		log.Printf("< %s\n", line)
+		log.Printf("%s %s\n", direction, s.options.Display.Format(line))
+		if direction != "<" {
+			return // only the received lines are dumped
+		}
+		for _, dumpLine := range s.options.Display.HexDumpLines(line) {
+			log.Printf("# %s\n", dumpLine)
+		}
 	}
 }
 
 func (s *Server) respPrintln(w io.Writer, line string) {
 	line = strings.TrimSpace(line)
+	s.lineVerbose(">", line+"\r\n")
+	_, err := fmt.Fprint(w, line+"\r\n")
+	if err != nil {
+		log.Printf("print error: %v", err)
+	}
+}
+
+// respWrite sends the content as it is, the verbose output shows it line by line.
+func (s *Server) respWrite(w io.Writer, content string) {
 	if s.verbose {
-		log.Printf("> %s\n", line)
+		for _, line := range strings.SplitAfter(content, "\n") {
+			if line != "" {
+				s.lineVerbose(">", line)
+			}
+		}
 	}
-	_, err := fmt.Fprint(w, line+"\r\n")
+	_, err := io.WriteString(w, content)
 	if err != nil {
 		log.Printf("print error: %v", err)
 	}
 }
 
-func (s *Server) PrintPlainTextResponse(w io.Writer, reqData *RequestData) {
+// printResponseHead sends the status line and the headers of a successful echo response, the content length frames the body.
+func (s *Server) printResponseHead(w io.Writer, reqData *RequestData, contentType string, contentLength int, keepAlive bool) {
 	s.respPrintln(w, "HTTP/1.1 200 OK")
-	s.respPrintln(w, "Content-Type: text/plain")
+	s.respPrintln(w, "Content-Type: "+contentType)
+	s.respPrintln(w, fmt.Sprintf("Content-Length: %d", contentLength))
+	if connection := reqData.connectionHeader(keepAlive); connection != "" {
+		s.respPrintln(w, "Connection: "+connection)
+	}
 	for key, values := range reqData.headers.EchoHeadersData {
 		for _, value := range values {
 			s.respPrintln(w, key+": "+value)
 		}
 	}
 	s.respPrintln(w, "")
-	s.respPrintln(w, "request-start-line: "+reqData.startLine)
-	s.respPrintln(w, "request-header-lines:")
-	for key, values := range reqData.headers.HeadersData {
-		for _, value := range values {
-			s.respPrintln(w, "- "+key+": "+value)
+}
+
+// echoText is the plain text echo response content, built before the head to send its length.
+type echoText struct {
+	strings.Builder
+}
+
+func (t *echoText) println(line string) {
+	t.WriteString(strings.TrimSpace(line) + "\r\n")
+}
+
+// plainTextEcho returns the plain text echo response content.
+func (s *Server) plainTextEcho(reqData *RequestData) string {
+	t := &echoText{}
+	if s.options.Display.ShowInvisible {
+		s.printVisibleLine(t, "request-start-line: ", reqData.rawStartLine)
+		s.printTargetAnalysis(t, reqData)
+		t.println("request-header-lines:")
+		for _, line := range reqData.rawHeaderLines {
+			s.printVisibleLine(t, "- ", line)
 		}
+		s.printVisibleLine(t, "request-header-end: ", reqData.rawHeaderEnd)
+	} else {
+		t.println("request-start-line: " + reqData.startLine)
+		s.printTargetAnalysis(t, reqData)
+		t.println("request-header-lines:")
+		for _, field := range reqData.headers.Fields {
+			t.println("- " + field.Name + ": " + field.Value)
+		}
+	}
+	if reqData.bodyPartial {
+		t.println(fmt.Sprintf("request-body-size: %s (partial, %d of %d bytes)", common.PrittyByteSize(reqData.bodySize), reqData.bodySize, reqData.contentLength))
+	} else {
+		t.println("request-body-size: " + common.PrittyByteSize(reqData.bodySize))
+	}
+	for _, hash := range reqData.bodyHashes {
+		t.println("request-body-hash: " + hash)
+	}
+	if reqData.describeBodyRequested() {
+		t.WriteString(reqData.describeBody().describe())
+	}
+	if len(reqData.violations) > 0 {
+		t.println("request-violations:")
+		for _, violation := range reqData.violations {
+			t.println("- " + violation)
+		}
+	}
+	if reqData.expect != nil {
+		t.println("request-expect: " + reqData.expect.describe())
+	}
+	for _, statusLine := range reqData.interimSent {
+		t.println("response-interim: " + statusLine)
+	}
+	t.println("request-read-duration: " + reqData.readDuration.String())
+	t.println("request-sleep-duration: " + reqData.sleepDuration.String())
+	t.println(fmt.Sprintf("connection-id: %d", reqData.connectionId))
+	t.println(fmt.Sprintf("connection-request-index: %d", reqData.requestIndex))
+	t.println("connection-remote-address: " + reqData.remoteAddress)
+	t.println("connection-local-address: " + reqData.localAddress)
+	if echoTls := newEchoTls(reqData.tlsState); echoTls != nil {
+		t.println("connection-tls: " + echoTls.describe())
+	}
+	if reqData.proxyHeader != nil {
+		t.println("proxy-protocol: " + reqData.proxyHeader.String())
+		for _, line := range reqData.proxyHeader.TLVLines() {
+			t.WriteString("- " + line + "\r\n")
+		}
+	}
+	if forwarding := reqData.analyzeForwarding(); forwarding != nil {
+		for _, line := range forwarding.describe() {
+			t.println(line)
+		}
+	}
+	return t.String()
+}
+
+func (s *Server) printTargetAnalysis(t *echoText, reqData *RequestData) {
+	target := reqData.analyzeTarget()
+	for _, line := range target.describe() {
+		t.println(line)
+	}
+}
+
+// printVisibleLine adds the raw request line with its invisible characters shown, followed by its hex dump when enabled.
+func (s *Server) printVisibleLine(t *echoText, label string, line string) {
+	t.println(label + common.VisibleLine(line))
+	for _, dumpLine := range s.options.Display.HexDumpLines(line) {
+		t.println("| " + dumpLine)
 	}
-	s.respPrintln(w, "request-body-size: "+common.PrittyByteSize(reqData.bodySize))
-	s.respPrintln(w, "request-body-hash: "+reqData.bodyHash)
-	s.respPrintln(w, "request-read-duration: "+reqData.readDuration.String())
-	s.respPrintln(w, "request-sleep-duration: "+reqData.sleepDuration.String())
 }
 
-func (s *Server) ReadRequestData(reader *bufio.Reader) *RequestData {
+// PrintErrorResponse sends a final error response, the message explains the reason to the client.
+func (s *Server) PrintErrorResponse(w io.Writer, statusCode int, message string) {
+	s.respPrintln(w, fmt.Sprintf("HTTP/1.1 %d %s", statusCode, http.StatusText(statusCode)))
+	s.respPrintln(w, "Content-Type: text/plain")
+	s.respPrintln(w, fmt.Sprintf("Content-Length: %d", len(message)+2))
+	s.respPrintln(w, "Connection: close")
+	s.respPrintln(w, "")
+	s.respPrintln(w, message)
+}
+
+// setReadDeadline limits the time of the next read phase, a zero timeout disables the limit.
+func (s *Server) setReadDeadline(conn net.Conn, timeout time.Duration) {
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		log.Printf("Error setting read deadline: %v", err)
+	}
+}
+
+func (s *Server) ReadRequestData(conn net.Conn, reader *bufio.Reader) *RequestData {
 	s.logVerbose("Read request: start")
 	readStart := time.Now().UnixMilli()
 	reqData := NewRequestData(false)
-	if requestLine, err := reader.ReadString('\n'); err == nil {
+	reqData.startTime = time.Now()
+	s.setReadDeadline(conn, s.options.ReadHeaderTimeout)
+	lineLimit := 0
+	if s.options.Strict {
+		lineLimit = maxConformingLineLength
+	}
+	if requestLine, err := readLine(reader, lineLimit); errors.Is(err, errLineTooLong) {
+		reqData.rawStartLine = requestLine
+		reqData.error = fmt.Errorf("%w: RFC 9112 section 3: the request line exceeds the limit of %d bytes", errUriTooLong, maxConformingLineLength)
+		log.Printf("rejected: %v", reqData.error)
+	} else if err != nil {
+		reqData.error = err
+	} else {
+		reqData.rawStartLine = requestLine
 		reqData.setStartLine(strings.TrimSpace(requestLine))
-		s.reqVerbose(reqData.startLine)
+		s.reqVerbose(requestLine)
 		// header
 		for {
-			line, err := reader.ReadString('\n')
+			line, err := readLine(reader, lineLimit)
+			if errors.Is(err, errLineTooLong) {
+				reqData.error = fmt.Errorf("%w: RFC 9110 section 5.4: the header line %d exceeds the limit of %d bytes", errHeaderTooLarge, len(reqData.rawHeaderLines)+1, maxConformingLineLength)
+				log.Printf("rejected: %v", reqData.error)
+				break
+			}
 			if err != nil {
 				log.Printf("read error: %v", err)
+				reqData.error = err
 				break
 			}
 			s.reqVerbose(line)
+			rawLine := line
 			line = strings.TrimSpace(line)
 			if line == "" {
+				reqData.rawHeaderEnd = rawLine
 				break // end of header
 			}
+			reqData.rawHeaderLines = append(reqData.rawHeaderLines, rawLine)
 			err = reqData.headers.AddLine(line)
 			if err != nil {
 				log.Printf("read header line '%s' error: %v", line, err)
 			}
 		}
+		if reqData.error == nil {
+			s.checkConformance(reqData)
+		}
 		// get data found in headers
 		if reqData.headers.SleepDuration > 0 {
 			reqData.sleepDuration = reqData.headers.SleepDuration
@@ -130,19 +383,16 @@ func (s *Server) ReadRequestData(reader *bufio.Reader) *RequestData {
 			reqData.contentLength = reqData.headers.ContentLength
 		}
 		// body
-		if reqData.method != "GET" && reqData.method != "HEAD" && reqData.contentLength > 0 {
-			s.logVerbose("Start of body reading")
-			body := make([]byte, reqData.contentLength)
-			_, err := io.ReadFull(reader, body)
-			if err == nil {
-				reqData.bodySize = len(body)
-				hashAlg := md5.New()
-				hashAlg.Write(body)
-				reqData.bodyHash = fmt.Sprintf("MD5:%x", hashAlg.Sum(nil))
-				s.logVerbose(fmt.Sprintf("body-size: %d", reqData.bodySize))
-				s.logVerbose(fmt.Sprintf("body-hash: %s", reqData.bodyHash))
+		if reqData.error == nil && reqData.method != "GET" && reqData.method != "HEAD" && reqData.contentLength > 0 {
+			if s.options.MaxBodySize > 0 && reqData.contentLength > s.options.MaxBodySize {
+				reqData.error = fmt.Errorf("%w: Content-Length %d exceeds the limit of %d bytes", errBodyTooLarge, reqData.contentLength, s.options.MaxBodySize)
+				log.Printf("read body error: %v", reqData.error)
+			} else if s.handleExpect(conn, reqData); reqData.error == nil {
+				s.logVerbose("Start of body reading")
+				s.setReadDeadline(conn, s.options.ReadBodyTimeout)
+				s.readBody(reader, reqData)
+				s.logVerbose("End of body reading")
 			}
-			s.logVerbose("End of body reading")
 		} else {
 			s.logVerbose("Body reading skipped")
 		}
@@ -152,14 +402,192 @@ func (s *Server) ReadRequestData(reader *bufio.Reader) *RequestData {
 	return reqData
 }
 
+// readProxyHeader reads the PROXY protocol header when it is enabled, the connection is dropped when false,
+// as a load balancer expects from a backend which does not get a valid header.
+func (s *Server) readProxyHeader(conn net.Conn, reader *bufio.Reader) (*common.ProxyHeader, bool) {
+	if s.options.ProxyProtocol == common.ProxyProtocolOff {
+		return nil, true
+	}
+	s.setReadDeadline(conn, s.options.ReadHeaderTimeout)
+	header, err := common.ReadProxyHeader(reader, s.options.ProxyProtocolVersion)
+	if err != nil {
+		log.Printf("PROXY protocol error from %s: %v", conn.RemoteAddr(), err)
+		return nil, false
+	}
+	if header == nil {
+		if s.options.ProxyProtocol == common.ProxyProtocolRequired {
+			log.Printf("PROXY protocol header required, connection from %s dropped", conn.RemoteAddr())
+			return nil, false
+		}
+		s.logVerbose("proxy-protocol: none")
+		return nil, true
+	}
+	if s.verbose {
+		if header.Version == 1 {
+			s.reqVerbose(string(header.Raw))
+		} else {
+			for _, dumpLine := range s.options.Display.HexDumpLines(string(header.Raw)) {
+				log.Printf("# %s\n", dumpLine)
+			}
+		}
+		s.logVerbose("proxy-protocol: " + header.String())
+		for _, line := range header.TLVLines() {
+			s.logVerbose("proxy-protocol-tlv: " + line)
+		}
+	}
+	return header, true
+}
+
+// readBody streams the body through the hashers, only its first bytes are kept in memory for the echo, the capture and the inspection.
+// When the client disconnects or times out mid-body, the size and hashes of the received part are reported.
+func (s *Server) readBody(reader io.Reader, reqData *RequestData) {
+	digest := common.NewMultiHash(s.options.BodyHashes)
+	buffer := &limitedBuffer{limit: max(s.options.CaptureMaxBodySize, maxBufferedBodySize)}
+	n, err := io.CopyN(io.MultiWriter(digest, buffer), reader, int64(reqData.contentLength))
+	reqData.bodySize = int(n)
+	reqData.bodyHashes = digest.Hashes()
+	reqData.bodyHash = reqData.bodyHashes[0]
+	if !buffer.overflow {
+		reqData.body = buffer.data
+	}
+	if err != nil {
+		reqData.bodyPartial = true
+		reqData.error = err
+		log.Printf("read body error after %d of %d bytes: %v", n, reqData.contentLength, err)
+	}
+	s.logVerbose(fmt.Sprintf("body-size: %d", reqData.bodySize))
+	for _, hash := range reqData.bodyHashes {
+		s.logVerbose(fmt.Sprintf("body-hash: %s", hash))
+	}
+}
+
+// limitedBuffer keeps the written bytes up to the limit, the whole content is dropped when it is exceeded.
+type limitedBuffer struct {
+	limit    int
+	data     []byte
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow && len(b.data)+len(p) > b.limit {
+		b.overflow, b.data = true, nil
+	}
+	if !b.overflow {
+		b.data = append(b.data, p...)
+	}
+	return len(p), nil
+}
+
 func (s *Server) handleTcpConnection(conn net.Conn) {
-	defer func(conn net.Conn) {
+	defer func() {
+		// the TLS connection replaces the plain one after the handshake
 		err := conn.Close()
 		if err != nil {
 			log.Printf("Error closing connection: %v", err)
 		}
-	}(conn)
-	reqData := s.ReadRequestData(bufio.NewReader(conn))
+	}()
+	connectionId := s.connections.Add(1)
+	reader := bufio.NewReader(conn)
+	proxyHeader, ok := s.readProxyHeader(conn, reader)
+	if !ok {
+		return
+	}
+	var tlsState *tls.ConnectionState
+	if s.tlsConfig != nil {
+		tlsConn, tlsReader, err := s.startTls(conn, reader)
+		if err != nil {
+			log.Printf("Connection from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		conn, reader, tlsState = tlsConn, tlsReader, &state
+	}
+	for requestIndex := 1; ; requestIndex++ {
+		if requestIndex > 1 && !s.awaitNextRequest(conn, reader) {
+			return
+		}
+		reqData := s.ReadRequestData(conn, reader)
+		reqData.connectionId = connectionId
+		reqData.requestIndex = requestIndex
+		reqData.proxyHeader = proxyHeader
+		reqData.tlsState = tlsState
+		reqData.remoteAddress = conn.RemoteAddr().String()
+		reqData.localAddress = conn.LocalAddr().String()
+		if !s.handleRequest(conn, reader, reqData) {
+			return
+		}
+		s.logVerbose(fmt.Sprintf("Connection %d kept alive after request %d", connectionId, requestIndex))
+	}
+}
+
+// awaitNextRequest waits for the next request on the persistent connection, false is returned when the client closes it
+// or stays idle longer than the idle timeout.
+func (s *Server) awaitNextRequest(conn net.Conn, reader *bufio.Reader) bool {
+	s.setReadDeadline(conn, s.options.IdleTimeout)
+	if _, err := reader.Peek(1); err != nil {
+		if isTimeout(err) {
+			s.logVerbose("Idle connection closed")
+		}
+		return false
+	}
+	return true
+}
+
+// handleRequest responds to the request, true is returned when the connection can serve the next one.
+func (s *Server) handleRequest(conn net.Conn, reader *bufio.Reader, reqData *RequestData) bool {
+	if path, ok := s.inspectPath(reqData); ok && reqData.error == nil {
+		s.serveInspection(conn, reqData, path)
+		return false
+	}
+	defer s.captureRequest(reqData)
+	if s.inspect != nil && reqData.captured() {
+		s.inspect.add(reqData, newEcho(reqData, s.options.Display))
+	}
+	if errors.Is(reqData.error, errExpectationRejected) {
+		s.PrintErrorResponse(conn, reqData.expect.rejectStatus, reqData.error.Error())
+		return false
+	}
+	if errors.Is(reqData.error, errMalformedRequest) {
+		s.PrintErrorResponse(conn, http.StatusBadRequest, reqData.error.Error())
+		return false
+	}
+	if errors.Is(reqData.error, errUriTooLong) {
+		s.PrintErrorResponse(conn, http.StatusRequestURITooLong, reqData.error.Error())
+		return false
+	}
+	if errors.Is(reqData.error, errHeaderTooLarge) {
+		s.PrintErrorResponse(conn, http.StatusRequestHeaderFieldsTooLarge, reqData.error.Error())
+		return false
+	}
+	if errors.Is(reqData.error, errBodyTooLarge) {
+		s.PrintErrorResponse(conn, http.StatusRequestEntityTooLarge, reqData.error.Error())
+		return false
+	}
+	if isTimeout(reqData.error) {
+		s.PrintErrorResponse(conn, http.StatusRequestTimeout, fmt.Sprintf("request read timeout: %v", reqData.error))
+		return false
+	}
+	if reqData.echoFormat() == EchoFormatMessage && len(reqData.body) < reqData.bodySize {
+		// the body above the buffer was only hashed, so the request cannot be returned as it was received
+		s.PrintErrorResponse(conn, http.StatusRequestEntityTooLarge, fmt.Sprintf("%v: the message/http echo cannot return the body of %d bytes, only %d bytes are kept",
+			errBodyTooLarge, reqData.bodySize, max(s.options.CaptureMaxBodySize, maxBufferedBodySize)))
+		return false
+	}
+	if reqData.webSocketRequested() {
+		s.serveWebSocket(conn, reader, reqData)
+		return false
+	}
+	stream, err := s.requestedStream(reqData)
+	if err != nil {
+		s.PrintErrorResponse(conn, http.StatusBadRequest, err.Error())
+		return false
+	}
+	eventStream, err := s.requestedEventStream(reqData)
+	if err != nil {
+		s.PrintErrorResponse(conn, http.StatusBadRequest, err.Error())
+		return false
+	}
+	s.sendInterimResponses(conn, reqData)
 	if reqData.sleepDuration.Milliseconds() > 0 {
 		readStart := time.Now().UnixMilli()
 		s.logVerbose(fmt.Sprintf("Going to sleep for %s", reqData.sleepDuration.String()))
@@ -167,10 +595,75 @@ func (s *Server) handleTcpConnection(conn net.Conn) {
 		actualSleepDuration := time.Duration(time.Now().UnixMilli()-readStart) * time.Millisecond
 		s.logVerbose(fmt.Sprintf("Woke up after %s", actualSleepDuration.String()))
 	}
-	s.PrintPlainTextResponse(conn, reqData)
+	keepAlive := s.options.KeepAlive && reqData.keepAlive()
+	if stream != nil {
+		return s.sendStreamResponse(conn, reqData, stream, keepAlive)
+	}
+	if eventStream != nil {
+		return s.sendEventStream(conn, reqData, eventStream, keepAlive)
+	}
+	s.PrintEchoResponse(conn, reqData, keepAlive)
+	return keepAlive
+}
+
+// keepAlive tells whether the client wants the connection to persist after the response (RFC 9112 section 9.3)
+// and the server has read the whole request, the unread chunked or GET body would be taken for the next request.
+func (r *RequestData) keepAlive() bool {
+	if r.error != nil || r.httpVersion == "" || len(r.headers.Values("Transfer-Encoding")) > 0 || r.bodySize != r.contentLength {
+		return false
+	}
+	options := map[string]bool{}
+	for _, option := range r.headerList("Connection") {
+		options[strings.ToLower(option)] = true
+	}
+	switch r.httpVersion {
+	case "HTTP/1.1":
+		return !options["close"]
+	case "HTTP/1.0":
+		return options["keep-alive"] && !options["close"]
+	}
+	return false
+}
+
+// connectionHeader returns the Connection header value announcing the persistence of the connection, when the default one does not apply.
+func (r *RequestData) connectionHeader(keepAlive bool) string {
+	switch {
+	case !keepAlive:
+		return "close"
+	case r.httpVersion == "HTTP/1.0":
+		return "keep-alive"
+	}
+	return ""
+}
+
+// captureRequest appends the request to the capture file, when it is enabled.
+func (s *Server) captureRequest(reqData *RequestData) {
+	if s.capture == nil || !reqData.captured() {
+		return
+	}
+	if err := s.capture.write(reqData); err != nil {
+		log.Printf("Error capturing request: %v", err)
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 func (s *Server) Serve() error {
+	if err := common.ValidateProxyProtocol(s.options.ProxyProtocol, s.options.ProxyProtocolVersion); err != nil {
+		return err
+	}
+	if _, err := parseExpectBehavior(s.options.ExpectContinue); err != nil {
+		return err
+	}
+	if err := validateInterimResponses(s.options.InterimResponses); err != nil {
+		return err
+	}
+	if s.options.SseInterval < 0 || s.options.SseCount < 0 {
+		return fmt.Errorf("invalid event stream settings: interval %s, count %d", s.options.SseInterval, s.options.SseCount)
+	}
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
 	if err != nil {
 		return fmt.Errorf("error setting up TCP server: %v\n", err)
@@ -181,7 +674,28 @@ func (s *Server) Serve() error {
 			fmt.Printf("Error closing TCP listener: %v\n", err)
 		}
 	}(ln)
+	if s.options.TlsCertFile != "" || s.options.TlsKeyFile != "" {
+		s.tlsConfig, err = loadTlsConfig(s.options.TlsCertFile, s.options.TlsKeyFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("TLS is enabled with the certificate %s\n", s.options.TlsCertFile)
+	}
+	if s.options.CaptureFile != "" {
+		s.capture, err = openCaptureLog(s.options.CaptureFile, s.options.CaptureMaxBodySize)
+		if err != nil {
+			return err
+		}
+		defer s.capture.close()
+		log.Printf("Capturing requests to %s\n", s.options.CaptureFile)
+	}
 
+	if s.inspect != nil {
+		log.Printf("Inspection API is available at %s/requests and %s/stats\n", s.options.InspectPrefix, s.options.InspectPrefix)
+	}
+	if s.options.ProxyProtocol != common.ProxyProtocolOff {
+		log.Printf("PROXY protocol header is %s (version %s)\n", s.options.ProxyProtocol, s.options.ProxyProtocolVersion)
+	}
 	log.Printf("TCP Server is running on :%d\n", s.port)
 	for {
 		conn, err := ln.Accept()
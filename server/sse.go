@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sseContentType = "text/event-stream"
+
+// sseEventData is the data of the emitted events, the send time lets the client measure the delivery delay of every event.
+type sseEventData struct {
+	Id         int       `json:"id"`
+	SentAt     time.Time `json:"sentAt"`
+	IntervalMs int64     `json:"intervalMs"`
+}
+
+// sseSettings are the number of events, 0 streams them until the client disconnects, and the interval between them.
+type sseSettings struct {
+	count    int
+	interval time.Duration
+}
+
+// requestedEventStream returns the event stream settings when the request asks for it by the rawh-sse query parameter or header,
+// the value is the number of events, or by 'Accept: text/event-stream'; nil is returned when the echo is requested.
+func (s *Server) requestedEventStream(reqData *RequestData) (*sseSettings, error) {
+	countSpec := reqData.override(common.SseQueryParamName, common.SseHeaderName)
+	if countSpec == "" && !reqData.acceptsEventStream() {
+		return nil, nil
+	}
+	settings := &sseSettings{count: s.options.SseCount, interval: s.options.SseInterval}
+	if settings.interval == 0 {
+		settings.interval = time.Second
+	}
+	var err error
+	if countSpec != "" {
+		if settings.count, err = strconv.Atoi(countSpec); err != nil || settings.count < 0 {
+			return nil, fmt.Errorf("invalid %s '%s', expected the number of events (0 streams them until the client disconnects)", common.SseQueryParamName, countSpec)
+		}
+	}
+	if spec := reqData.override(common.SseIntervalQueryParamName, common.SseIntervalHeaderName); spec != "" {
+		if settings.interval, err = time.ParseDuration(spec); err != nil || settings.interval <= 0 {
+			return nil, fmt.Errorf("invalid %s '%s', expected a duration like '500ms'", common.SseIntervalQueryParamName, spec)
+		}
+	}
+	return settings, nil
+}
+
+func (r *RequestData) acceptsEventStream() bool {
+	for _, value := range r.headers.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange)); err == nil && mediaType == sseContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sendEventStream emits the timestamped events, every one in its own chunk, so a proxy buffering or coalescing the stream
+// shows up in the receive times. HTTP/1.0 clients get the stream framed by the connection close.
+// True is returned when the connection can serve the next request.
+func (s *Server) sendEventStream(conn net.Conn, reqData *RequestData, settings *sseSettings, keepAlive bool) bool {
+	chunked := reqData.httpVersion != "HTTP/1.0"
+	keepAlive = keepAlive && chunked && settings.count > 0
+	s.respPrintln(conn, "HTTP/1.1 200 OK")
+	s.respPrintln(conn, "Content-Type: "+sseContentType)
+	s.respPrintln(conn, "Cache-Control: no-cache")
+	if chunked {
+		s.respPrintln(conn, common.TransferEncodingHeaderName+": chunked")
+	}
+	if connection := reqData.connectionHeader(keepAlive); connection != "" {
+		s.respPrintln(conn, "Connection: "+connection)
+	}
+	s.respPrintln(conn, "")
+	if reqData.method == "HEAD" {
+		return keepAlive
+	}
+	for id := 1; settings.count == 0 || id <= settings.count; id++ {
+		if id > 1 {
+			time.Sleep(settings.interval)
+		}
+		data, err := json.Marshal(sseEventData{Id: id, SentAt: time.Now().UTC(), IntervalMs: settings.interval.Milliseconds()})
+		if err != nil {
+			return false
+		}
+		event := fmt.Sprintf("id: %d\nevent: tick\ndata: %s\n\n", id, data)
+		if chunked {
+			event = fmt.Sprintf("%x\r\n%s\r\n", len(event), event)
+		}
+		if err := s.writeEvent(conn, event); err != nil {
+			s.logVerbose(fmt.Sprintf("Event stream closed after %d events: %v", id-1, err))
+			return false
+		}
+	}
+	if chunked {
+		s.respPrintln(conn, "0")
+		s.respPrintln(conn, "")
+	}
+	return keepAlive
+}
+
+// writeEvent sends the event as respWrite does, the write error ends the stream.
+func (s *Server) writeEvent(conn net.Conn, event string) error {
+	if s.verbose {
+		for _, line := range strings.SplitAfter(event, "\n") {
+			if line != "" {
+				s.lineVerbose(">", line)
+			}
+		}
+	}
+	_, err := conn.Write([]byte(event))
+	return err
+}
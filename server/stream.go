@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"rawh/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStreamChunkSize = 64
+	defaultStreamInterval  = time.Second
+	maxStreamChunkSize     = 1024 * 1024
+)
+
+// streamSettings shape the streamed response which replaces the echo: count chunks of chunkSize bytes sent every interval,
+// followed by the trailer lines. A contentLength of 0 or more replaces the chunked coding with the deliberately wrong
+// Content-Length, the connection is closed after the body then.
+type streamSettings struct {
+	count         int
+	chunkSize     int
+	interval      time.Duration
+	trailerLines  []string
+	contentLength int
+}
+
+// requestedStream returns the settings requested by the rawh-stream query parameters or headers, nil when the echo is requested.
+func (s *Server) requestedStream(reqData *RequestData) (*streamSettings, error) {
+	countSpec := reqData.override(common.StreamQueryParamName, common.StreamHeaderName)
+	if countSpec == "" {
+		return nil, nil
+	}
+	settings := &streamSettings{chunkSize: defaultStreamChunkSize, interval: defaultStreamInterval, contentLength: -1}
+	var err error
+	if settings.count, err = strconv.Atoi(countSpec); err != nil || settings.count < 1 {
+		return nil, fmt.Errorf("invalid %s '%s', expected the chunk count", common.StreamQueryParamName, countSpec)
+	}
+	if spec := reqData.override(common.StreamChunkSizeQueryParamName, common.StreamChunkSizeHeaderName); spec != "" {
+		if settings.chunkSize, err = strconv.Atoi(spec); err != nil || settings.chunkSize < 1 || settings.chunkSize > maxStreamChunkSize {
+			return nil, fmt.Errorf("invalid %s '%s', expected 1 to %d bytes", common.StreamChunkSizeQueryParamName, spec, maxStreamChunkSize)
+		}
+	}
+	if spec := reqData.override(common.StreamIntervalQueryParamName, common.StreamIntervalHeaderName); spec != "" {
+		if settings.interval, err = time.ParseDuration(spec); err != nil || settings.interval < 0 {
+			return nil, fmt.Errorf("invalid %s '%s', expected a duration like '500ms'", common.StreamIntervalQueryParamName, spec)
+		}
+	}
+	if spec := reqData.override(common.StreamContentLengthQueryParamName, common.StreamContentLengthHeaderName); spec != "" {
+		if settings.contentLength, err = strconv.Atoi(spec); err != nil || settings.contentLength < 0 {
+			return nil, fmt.Errorf("invalid %s '%s', expected a byte count", common.StreamContentLengthQueryParamName, spec)
+		}
+	}
+	var trailers []string
+	if target, err := url.Parse(reqData.requestURI); err == nil {
+		trailers = target.Query()[common.StreamTrailerQueryParamName]
+	}
+	for _, line := range append(trailers, reqData.headers.Values(common.StreamTrailerHeaderName)...) {
+		if _, _, err := common.SplitHeaderLine(line); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", common.StreamTrailerQueryParamName, line, err)
+		}
+		settings.trailerLines = append(settings.trailerLines, strings.TrimSpace(line))
+	}
+	return settings, nil
+}
+
+// trailerNames returns the trailer field names as they were requested, for the Trailer header.
+func (settings *streamSettings) trailerNames() []string {
+	names := make([]string, 0, len(settings.trailerLines))
+	for _, line := range settings.trailerLines {
+		name, _, _ := common.SplitHeaderLine(line)
+		names = append(names, name)
+	}
+	return names
+}
+
+// streamChunk returns the chunk data: its number and the time since the response start, padded with dots to the size.
+func streamChunk(number int, count int, size int, elapsed time.Duration) []byte {
+	data := []byte(strings.Repeat(".", size))
+	copy(data, fmt.Sprintf("chunk %d/%d +%s ", number, count, elapsed.Round(time.Millisecond)))
+	data[size-1] = '\n'
+	return data
+}
+
+// sendStreamResponse sends the chunks one by one, so a buffering proxy shows up as the chunks arriving at once,
+// and the trailers, which a proxy may strip. HTTP/1.0 clients get the body framed by the connection close.
+// True is returned when the connection can serve the next request.
+func (s *Server) sendStreamResponse(conn net.Conn, reqData *RequestData, settings *streamSettings, keepAlive bool) bool {
+	chunked := settings.contentLength < 0 && reqData.httpVersion != "HTTP/1.0"
+	keepAlive = keepAlive && chunked
+	s.respPrintln(conn, "HTTP/1.1 200 OK")
+	s.respPrintln(conn, "Content-Type: text/plain")
+	switch {
+	case chunked:
+		s.respPrintln(conn, common.TransferEncodingHeaderName+": chunked")
+		if len(settings.trailerLines) > 0 {
+			s.respPrintln(conn, common.TrailerHeaderName+": "+strings.Join(settings.trailerNames(), ", "))
+		}
+	case settings.contentLength >= 0:
+		s.respPrintln(conn, fmt.Sprintf("Content-Length: %d", settings.contentLength))
+		s.logVerbose(fmt.Sprintf("Stream: Content-Length %d declared, %d bytes sent", settings.contentLength, settings.count*settings.chunkSize))
+	}
+	if connection := reqData.connectionHeader(keepAlive); connection != "" {
+		s.respPrintln(conn, "Connection: "+connection)
+	}
+	s.respPrintln(conn, "")
+	if reqData.method == "HEAD" {
+		return keepAlive
+	}
+	start := time.Now()
+	for number := 1; number <= settings.count; number++ {
+		if number > 1 && settings.interval > 0 {
+			time.Sleep(settings.interval)
+		}
+		data := string(streamChunk(number, settings.count, settings.chunkSize, time.Since(start)))
+		if chunked {
+			data = fmt.Sprintf("%x\r\n%s\r\n", len(data), data)
+		}
+		s.respWrite(conn, data)
+	}
+	if chunked {
+		s.respPrintln(conn, "0")
+		for _, line := range settings.trailerLines {
+			s.respPrintln(conn, line)
+		}
+		s.respPrintln(conn, "")
+	} else if len(settings.trailerLines) > 0 {
+		s.logVerbose("Stream: trailers skipped, they need the chunked coding")
+	}
+	return keepAlive
+}
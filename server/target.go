@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	TargetFormOrigin    = "origin"
+	TargetFormAbsolute  = "absolute"
+	TargetFormAuthority = "authority"
+	TargetFormAsterisk  = "asterisk"
+)
+
+// EchoTarget is the request-target analysis (RFC 9112 section 3.2), the flags point out what proxies and backends may normalize differently.
+type EchoTarget struct {
+	Form        string           `json:"form"`
+	Scheme      string           `json:"scheme,omitempty"`
+	Authority   string           `json:"authority,omitempty"`
+	RawPath     string           `json:"rawPath"`
+	DecodedPath string           `json:"decodedPath"`
+	RawQuery    string           `json:"rawQuery,omitempty"`
+	Query       []EchoQueryParam `json:"query"`
+	Fragment    string           `json:"fragment,omitempty"`
+	Flags       []string         `json:"flags"`
+}
+
+type EchoQueryParam struct {
+	RawName  string `json:"rawName"`
+	RawValue string `json:"rawValue"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+}
+
+// analyzeRequestTarget parses the request-target as it was received, nothing is normalized.
+func analyzeRequestTarget(method string, target string) EchoTarget {
+	analysis := EchoTarget{Query: []EchoQueryParam{}, Flags: []string{}}
+	rest := target
+	switch {
+	case target == "*":
+		analysis.Form = TargetFormAsterisk
+		return analysis
+	case strings.HasPrefix(target, "/"):
+		analysis.Form = TargetFormOrigin
+	case method == "CONNECT" || !strings.Contains(target, "://"):
+		analysis.Form = TargetFormAuthority
+		analysis.Authority = target
+		return analysis
+	default:
+		analysis.Form = TargetFormAbsolute
+		analysis.Scheme, rest, _ = strings.Cut(target, "://")
+		analysis.Authority, rest = rest, ""
+		if i := strings.IndexAny(analysis.Authority, "/?#"); i >= 0 {
+			analysis.Authority, rest = analysis.Authority[:i], analysis.Authority[i:]
+		}
+	}
+	rest, analysis.Fragment, _ = strings.Cut(rest, "#")
+	if strings.Contains(target, "#") {
+		analysis.addFlag("fragment")
+	}
+	analysis.RawPath, analysis.RawQuery, _ = strings.Cut(rest, "?")
+	analysis.DecodedPath = percentDecode(analysis.RawPath)
+	analysis.analyzeEscapes(target)
+	analysis.analyzeSegments()
+	analysis.Query = parseQueryParams(analysis.RawQuery)
+	return analysis
+}
+
+// parseQueryParams returns the url-encoded parameters in their original order, with raw and decoded names and values.
+func parseQueryParams(query string) []EchoQueryParam {
+	params := []EchoQueryParam{}
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		param := EchoQueryParam{}
+		param.RawName, param.RawValue, _ = strings.Cut(pair, "=")
+		param.Name, param.Value = queryDecode(param.RawName), queryDecode(param.RawValue)
+		params = append(params, param)
+	}
+	return params
+}
+
+// analyzeTarget analyzes the request-target of the request line, irregular whitespace around it is flagged too.
+func (r *RequestData) analyzeTarget() EchoTarget {
+	analysis := analyzeRequestTarget(r.method, r.requestURI)
+	if r.startLine != r.method+" "+r.requestURI+" "+r.httpVersion {
+		analysis.addFlag("request-line-whitespace")
+	}
+	return analysis
+}
+
+func (t *EchoTarget) addFlag(flag string) {
+	for _, existing := range t.Flags {
+		if existing == flag {
+			return
+		}
+	}
+	t.Flags = append(t.Flags, flag)
+}
+
+// analyzeEscapes flags the percent escapes which decode differently depending on how many times and how strictly they are decoded,
+// the mixed case means both upper- and lowercase hex digits appear in the target.
+func (t *EchoTarget) analyzeEscapes(target string) {
+	lowerHex, upperHex := false, false
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		switch {
+		case c >= 0x80:
+			t.addFlag("non-ascii")
+		case c < 0x20 || c == 0x7f || c == ' ':
+			t.addFlag("control-or-space")
+		case c == '\\':
+			t.addFlag("backslash")
+		case c == '%':
+			if i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2]) {
+				t.addFlag("invalid-percent-escape")
+				continue
+			}
+			escape := strings.ToUpper(target[i+1 : i+3])
+			for _, digit := range []byte(target[i+1 : i+3]) {
+				lowerHex = lowerHex || (digit >= 'a' && digit <= 'f')
+				upperHex = upperHex || (digit >= 'A' && digit <= 'F')
+			}
+			if lowerHex && upperHex {
+				t.addFlag("mixed-case-percent-escape")
+			}
+			switch {
+			case escape == "2F" || escape == "5C":
+				t.addFlag("encoded-slash")
+			case escape == "25" && i+4 < len(target) && isHex(target[i+3]) && isHex(target[i+4]):
+				t.addFlag("double-encoding")
+			case escape == "00":
+				t.addFlag("encoded-nul")
+			case escape[0] >= '8':
+				t.addFlag("encoded-non-ascii")
+			}
+			i += 2
+		}
+	}
+}
+
+// analyzeSegments flags the dot-segments, also the percent-encoded ones, and the empty segments of the path.
+func (t *EchoTarget) analyzeSegments() {
+	for i, segment := range strings.Split(t.RawPath, "/") {
+		switch strings.ToLower(segment) {
+		case ".", "..", "%2e", "%2e%2e", ".%2e", "%2e.":
+			t.addFlag("dot-segments")
+		case "":
+			if i > 0 && i < len(strings.Split(t.RawPath, "/"))-1 {
+				t.addFlag("empty-segment")
+			}
+		}
+	}
+}
+
+// describe returns the analysis lines of the plain text echo response.
+func (t *EchoTarget) describe() []string {
+	lines := []string{"request-target-form: " + t.Form}
+	if t.Authority != "" {
+		lines = append(lines, "request-target-authority: "+t.Authority)
+	}
+	if t.Form == TargetFormOrigin || t.Form == TargetFormAbsolute {
+		lines = append(lines, "request-target-path: "+t.RawPath)
+		lines = append(lines, "request-target-decoded-path: "+t.DecodedPath)
+	}
+	if len(t.Query) > 0 {
+		lines = append(lines, "request-target-query:")
+		for _, param := range t.Query {
+			lines = append(lines, fmt.Sprintf("- %s=%s (%q = %q)", param.RawName, param.RawValue, param.Name, param.Value))
+		}
+	}
+	if len(t.Flags) > 0 {
+		lines = append(lines, "request-target-flags: "+strings.Join(t.Flags, ", "))
+	}
+	return lines
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// percentDecode decodes the valid percent escapes once and keeps the invalid ones as they are.
+func percentDecode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// queryDecode decodes the query component as HTML forms do, '+' is a space.
+func queryDecode(s string) string {
+	if decoded, err := url.QueryUnescape(s); err == nil {
+		return decoded
+	}
+	return percentDecode(strings.ReplaceAll(s, "+", " "))
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
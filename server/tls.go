@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// EchoTls describes the TLS connection the request was received on.
+type EchoTls struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipherSuite"`
+	ServerName         string `json:"serverName,omitempty"`
+	NegotiatedProtocol string `json:"negotiatedProtocol,omitempty"`
+	Resumed            bool   `json:"resumed"`
+	// PeerCertificates are the subjects of the client certificates, the server does not request them.
+	PeerCertificates []string `json:"peerCertificates,omitempty"`
+}
+
+func newEchoTls(state *tls.ConnectionState) *EchoTls {
+	if state == nil {
+		return nil
+	}
+	echoTls := &EchoTls{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		Resumed:            state.DidResume,
+	}
+	for _, certificate := range state.PeerCertificates {
+		echoTls.PeerCertificates = append(echoTls.PeerCertificates, certificate.Subject.String())
+	}
+	return echoTls
+}
+
+// describe returns the one line description of the plain text echo response.
+func (t *EchoTls) describe() string {
+	parts := []string{t.Version, t.CipherSuite}
+	if t.ServerName != "" {
+		parts = append(parts, "sni "+t.ServerName)
+	}
+	if t.NegotiatedProtocol != "" {
+		parts = append(parts, "alpn "+t.NegotiatedProtocol)
+	}
+	if t.Resumed {
+		parts = append(parts, "resumed")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func loadTlsConfig(certFile string, keyFile string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading TLS certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, NextProtos: []string{"http/1.1"}}, nil
+}
+
+// bufferedConn reads through the reader which may hold the bytes following the PROXY header, the TLS handshake starts there.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// startTls performs the TLS handshake on the connection, the returned connection and reader replace the plain ones.
+func (s *Server) startTls(conn net.Conn, reader *bufio.Reader) (*tls.Conn, *bufio.Reader, error) {
+	s.setReadDeadline(conn, s.options.ReadHeaderTimeout)
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: reader}, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, fmt.Errorf("TLS handshake error: %v", err)
+	}
+	state := tlsConn.ConnectionState()
+	s.logVerbose(fmt.Sprintf("tls: %s", newEchoTls(&state).describe()))
+	return tlsConn, bufio.NewReader(tlsConn), nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"rawh/common"
+	"strings"
+)
+
+// maxWebSocketPayload limits the frame payload and the message echoed back, the larger ones close the connection with 1009.
+const maxWebSocketPayload = 16 * 1024 * 1024
+
+// webSocketRequested reports whether the request asks for the upgrade to the WebSocket protocol.
+func (r *RequestData) webSocketRequested() bool {
+	for _, protocol := range r.headerList("Upgrade") {
+		if strings.EqualFold(protocol, "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// webSocketHandshakeError returns why the upgrade request is not a valid opening handshake (RFC 6455 section 4.2.1), empty when it is.
+func (r *RequestData) webSocketHandshakeError() string {
+	switch {
+	case r.method != "GET":
+		return "the opening handshake method must be GET, not " + r.method
+	case r.httpVersion != "HTTP/1.1":
+		return "the opening handshake must use HTTP/1.1, not " + r.httpVersion
+	case !r.hasToken("Connection", "upgrade"):
+		return "the Connection header does not contain 'Upgrade'"
+	case strings.Join(r.headers.Values("Sec-WebSocket-Version"), ",") != "13":
+		return "the Sec-WebSocket-Version header must be 13"
+	}
+	keys := r.headers.Values("Sec-WebSocket-Key")
+	if len(keys) != 1 {
+		return fmt.Sprintf("exactly one Sec-WebSocket-Key header is required, got %d", len(keys))
+	}
+	if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(keys[0])); err != nil || len(key) != 16 {
+		return "the Sec-WebSocket-Key header must be 16 bytes encoded in base64"
+	}
+	return ""
+}
+
+func (r *RequestData) hasToken(headerName string, token string) bool {
+	for _, item := range r.headerList(headerName) {
+		if strings.EqualFold(item, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveWebSocket completes the opening handshake, sends the echo of the handshake request as the first text message,
+// and then describes every received frame in a text message and echoes the data messages, the pings and the close frame.
+// No extension is negotiated and the first offered subprotocol is selected.
+func (s *Server) serveWebSocket(conn net.Conn, reader *bufio.Reader, reqData *RequestData) {
+	if reason := reqData.webSocketHandshakeError(); reason != "" {
+		s.respPrintln(conn, fmt.Sprintf("HTTP/1.1 %d %s", http.StatusBadRequest, http.StatusText(http.StatusBadRequest)))
+		s.respPrintln(conn, "Content-Type: text/plain")
+		s.respPrintln(conn, fmt.Sprintf("Content-Length: %d", len(reason)+2))
+		s.respPrintln(conn, "Sec-WebSocket-Version: 13")
+		s.respPrintln(conn, "Connection: close")
+		s.respPrintln(conn, "")
+		s.respPrintln(conn, reason)
+		return
+	}
+	s.respPrintln(conn, "HTTP/1.1 101 Switching Protocols")
+	s.respPrintln(conn, "Upgrade: websocket")
+	s.respPrintln(conn, "Connection: Upgrade")
+	s.respPrintln(conn, "Sec-WebSocket-Accept: "+common.WebSocketAccept(strings.TrimSpace(reqData.headers.Values("Sec-WebSocket-Key")[0])))
+	if protocols := reqData.headerList("Sec-WebSocket-Protocol"); len(protocols) > 0 {
+		s.respPrintln(conn, "Sec-WebSocket-Protocol: "+protocols[0])
+	}
+	s.respPrintln(conn, "")
+	content, err := s.echoContent(reqData, reqData.echoFormat())
+	if err != nil {
+		content = "error encoding echo: " + err.Error()
+	}
+	if !s.sendWebSocketFrame(conn, common.WebSocketOpText, []byte(content)) {
+		return
+	}
+	s.setReadDeadline(conn, 0)
+	s.echoWebSocketFrames(conn, reader)
+}
+
+// echoWebSocketFrames reads the frames until the close handshake, the protocol violations close the connection with 1002.
+func (s *Server) echoWebSocketFrames(conn net.Conn, reader *bufio.Reader) {
+	var messageOpcode byte
+	var message []byte
+	for {
+		frame, err := common.ReadWebSocketFrame(reader, maxWebSocketPayload)
+		if err != nil {
+			if frame != nil {
+				s.lineVerbose("<", "ws-frame: "+frame.Describe())
+				s.closeWebSocket(conn, 1009, err.Error())
+			} else if !errors.Is(err, io.EOF) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		description := frame.Describe()
+		s.lineVerbose("<", "ws-frame: "+description)
+		if !s.sendWebSocketFrame(conn, common.WebSocketOpText, []byte("frame: "+description)) {
+			return
+		}
+		violation := ""
+		switch {
+		case !frame.Masked:
+			violation = "unmasked client frame"
+		case frame.Rsv != 0:
+			violation = "reserved bits set without a negotiated extension"
+		case frame.IsControl() && (!frame.Fin || frame.Length > 125):
+			violation = "fragmented or too long control frame"
+		case frame.Opcode == common.WebSocketOpContinuation && messageOpcode == 0:
+			violation = "continuation frame without a message start"
+		case (frame.Opcode == common.WebSocketOpText || frame.Opcode == common.WebSocketOpBinary) && messageOpcode != 0:
+			violation = "new message before the fragmented one ended"
+		case !frame.IsControl() && frame.Opcode > common.WebSocketOpBinary:
+			violation = "reserved opcode"
+		}
+		if violation != "" {
+			s.closeWebSocket(conn, 1002, violation)
+			return
+		}
+		switch frame.Opcode {
+		case common.WebSocketOpClose:
+			code, reason := common.ParseWebSocketClosePayload(frame.Payload)
+			if code == 0 {
+				code = 1000
+			}
+			s.closeWebSocket(conn, code, reason)
+			return
+		case common.WebSocketOpPing:
+			if !s.sendWebSocketFrame(conn, common.WebSocketOpPong, frame.Payload) {
+				return
+			}
+			continue
+		case common.WebSocketOpPong:
+			continue
+		case common.WebSocketOpText, common.WebSocketOpBinary:
+			messageOpcode, message = frame.Opcode, nil
+		}
+		if len(message)+len(frame.Payload) > maxWebSocketPayload {
+			s.closeWebSocket(conn, 1009, "message too large")
+			return
+		}
+		message = append(message, frame.Payload...)
+		if frame.Fin {
+			if !s.sendWebSocketFrame(conn, messageOpcode, message) {
+				return
+			}
+			messageOpcode, message = 0, nil
+		}
+	}
+}
+
+// sendWebSocketFrame sends the unfragmented server frame, false is returned when the connection is broken.
+func (s *Server) sendWebSocketFrame(conn net.Conn, opcode byte, payload []byte) bool {
+	frame := &common.WebSocketFrame{Fin: true, Opcode: opcode, Payload: payload}
+	err := common.WriteWebSocketFrame(conn, frame)
+	s.lineVerbose(">", "ws-frame: "+frame.Describe())
+	if err != nil {
+		log.Printf("WebSocket write error: %v", err)
+		return false
+	}
+	return true
+}
+
+// closeWebSocket sends the close frame, the connection is closed by the caller without waiting for the reply.
+func (s *Server) closeWebSocket(conn net.Conn, code int, reason string) {
+	s.sendWebSocketFrame(conn, common.WebSocketOpClose, common.NewWebSocketClosePayload(code, reason))
+}